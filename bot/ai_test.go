@@ -0,0 +1,129 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func jsonResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       io.NopCloser(bytes.NewBufferString(body)),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+	}
+}
+
+func TestProduceTelegramTextUsesOpenAI(t *testing.T) {
+	var gotURL, gotAuth string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		gotURL = req.URL.String()
+		gotAuth = req.Header.Get("Authorization")
+		return jsonResponse(200, `{"choices":[{"message":{"role":"assistant","content":"`+"```"+`markdown\nHello from OpenAI\n`+"```"+`"}}]}`), nil
+	})}
+	cfg := Config{AIEnabled: true, AIProvider: "openai", AIModel: "gpt-4o-mini", OpenAIAPIKey: "sk-test"}
+
+	text, err := produceTelegramText(context.Background(), client, cfg, map[string]any{"top_n": 100})
+	if err != nil {
+		t.Fatalf("produceTelegramText error: %v", err)
+	}
+	if text != "Hello from OpenAI" {
+		t.Fatalf("unexpected text: %q", text)
+	}
+	if !strings.HasSuffix(gotURL, "/v1/chat/completions") {
+		t.Fatalf("unexpected url: %s", gotURL)
+	}
+	if gotAuth != "Bearer sk-test" {
+		t.Fatalf("unexpected auth header: %q", gotAuth)
+	}
+}
+
+func TestProduceTelegramTextFallsBackOnProviderError(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return jsonResponse(500, `{"error":{"message":"boom"}}`), nil
+	})}
+	cfg := Config{AIEnabled: true, AIProvider: "openai", OpenAIAPIKey: "sk-test"}
+	renderCtx := map[string]any{"top_n": 100, "convert": "USD"}
+
+	text, err := produceTelegramText(context.Background(), client, cfg, renderCtx)
+	if err != nil {
+		t.Fatalf("produceTelegramText error: %v", err)
+	}
+	if want := RenderTemplate(defaultFallbackTemplate, renderCtx); text != want {
+		t.Fatalf("expected fallback template, got %q", text)
+	}
+}
+
+func TestProduceTelegramTextUnknownProviderUsesTemplate(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Fatalf("unexpected request to %s", req.URL)
+		return nil, nil
+	})}
+	cfg := Config{AIEnabled: true, AIProvider: "claude", GeminiAPIKey: "g", OpenAIAPIKey: "o"}
+	renderCtx := map[string]any{"top_n": 100, "convert": "USD"}
+
+	text, err := produceTelegramText(context.Background(), client, cfg, renderCtx)
+	if err != nil {
+		t.Fatalf("produceTelegramText error: %v", err)
+	}
+	if want := RenderTemplate(defaultFallbackTemplate, renderCtx); text != want {
+		t.Fatalf("expected fallback template, got %q", text)
+	}
+}
+
+func TestGeminiRequestPayloadOmitsUnsetGenerationSettings(t *testing.T) {
+	body, _ := json.Marshal(geminiRequestPayload(Config{}, "prompt"))
+	var parsed map[string]any
+	_ = json.Unmarshal(body, &parsed)
+	if _, ok := parsed["generationConfig"]; ok {
+		t.Fatalf("generationConfig should be omitted by default: %s", body)
+	}
+	if _, ok := parsed["system_instruction"]; ok {
+		t.Fatalf("system_instruction should be omitted by default: %s", body)
+	}
+}
+
+func TestCallGeminiSendsGenerationSettings(t *testing.T) {
+	var sent map[string]any
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		b, _ := io.ReadAll(req.Body)
+		_ = json.Unmarshal(b, &sent)
+		return jsonResponse(200, `{"candidates":[{"content":{"parts":[{"text":"ok"}]}}]}`), nil
+	})}
+	temp := 0.4
+	cfg := Config{AIModel: "gemini-3-flash-preview", GeminiAPIKey: "g", AITemperature: &temp, AIMaxOutputTokens: 512, AISystemInstruction: "Be terse."}
+
+	if _, err := callGemini(context.Background(), client, cfg, "prompt"); err != nil {
+		t.Fatalf("callGemini error: %v", err)
+	}
+	genCfg, _ := sent["generationConfig"].(map[string]any)
+	if genCfg["temperature"] != 0.4 || genCfg["maxOutputTokens"] != float64(512) {
+		t.Fatalf("unexpected generationConfig: %v", sent["generationConfig"])
+	}
+	sys, _ := sent["system_instruction"].(map[string]any)
+	parts, _ := sys["parts"].([]any)
+	if len(parts) != 1 || parts[0].(map[string]any)["text"] != "Be terse." {
+		t.Fatalf("unexpected system_instruction: %v", sent["system_instruction"])
+	}
+}
+
+func TestGeminiRequestPayloadTemperatureZeroIsKept(t *testing.T) {
+	zero := 0.0
+	payload := geminiRequestPayload(Config{AITemperature: &zero}, "prompt")
+	genCfg, _ := payload["generationConfig"].(map[string]any)
+	if v, ok := genCfg["temperature"]; !ok || v != 0.0 {
+		t.Fatalf("explicit zero temperature should be sent: %v", payload)
+	}
+	if _, ok := genCfg["maxOutputTokens"]; ok {
+		t.Fatalf("maxOutputTokens should be omitted when unset: %v", genCfg)
+	}
+}
@@ -2,21 +2,27 @@ package bot
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html"
 	"io"
 	"log"
+	"math"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
@@ -33,6 +39,9 @@ const defaultFallbackTemplate = `🚀 New entries in CoinMarketCap Top %top_n% (
 %END_EACH%%IF exited_coins%
 📉 Exited:
 %EACH exited_coins%• #%rank% %name% (%symbol%)
+%END_EACH%%END_IF%%IF moved_coins%
+↕️ Rank jumps:
+%EACH moved_coins%• #%prev_rank% → #%rank% %name% (%symbol%)
 %END_EACH%%END_IF%`
 
 type RunOptions struct {
@@ -53,11 +62,22 @@ type Config struct {
 	MongoDBStateCollection   string
 	MongoDBCoinsCollection   string
 	MongoDBHistoryCollection string
+	MongoDBLogosCollection   string
+	LogoCacheTTL             time.Duration
 	TopN                     int
 	AIEnabled                bool
 	AIProvider               string
 	AIModel                  string
 	GeminiAPIKey             string
+	OpenAIAPIKey             string
+	AITemperature            *float64
+	AIMaxOutputTokens        int
+	AISystemInstruction      string
+	CMCMaxRetries            int
+	CMCRetryBase             time.Duration
+	RankJumpThreshold        int
+	DedupWindow              time.Duration
+	HysteresisMargin         int
 }
 
 func ConfigFromEnv(dryRun bool, skipMongo bool) (Config, error) {
@@ -101,11 +121,89 @@ func ConfigFromEnv(dryRun bool, skipMongo bool) (Config, error) {
 		}
 		topN = n
 	}
+	cmcMaxRetries := 3
+	if raw := strings.TrimSpace(os.Getenv("CMC_MAX_RETRIES")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return Config{}, errors.New("CMC_MAX_RETRIES must be a non-negative integer")
+		}
+		cmcMaxRetries = n
+	}
+	cmcRetryBaseMS := 500
+	if raw := strings.TrimSpace(os.Getenv("CMC_RETRY_BASE_MS")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return Config{}, errors.New("CMC_RETRY_BASE_MS must be a non-negative integer")
+		}
+		cmcRetryBaseMS = n
+	}
+	rankJumpThreshold := 0
+	if raw := strings.TrimSpace(os.Getenv("RANK_JUMP_THRESHOLD")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return Config{}, errors.New("RANK_JUMP_THRESHOLD must be a non-negative integer")
+		}
+		rankJumpThreshold = n
+	}
+	hysteresisMargin := 0
+	if raw := strings.TrimSpace(os.Getenv("HYSTERESIS_MARGIN")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 || n >= topN {
+			return Config{}, errors.New("HYSTERESIS_MARGIN must be a non-negative integer smaller than TOP_N")
+		}
+		hysteresisMargin = n
+	}
+	dedupWindowMinutes := 0
+	if raw := strings.TrimSpace(os.Getenv("DEDUP_WINDOW_MINUTES")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return Config{}, errors.New("DEDUP_WINDOW_MINUTES must be a non-negative integer")
+		}
+		dedupWindowMinutes = n
+	}
+	logoCacheTTLHours := 720
+	if raw := strings.TrimSpace(os.Getenv("LOGO_CACHE_TTL_HOURS")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return Config{}, errors.New("LOGO_CACHE_TTL_HOURS must be a non-negative integer")
+		}
+		logoCacheTTLHours = n
+	}
 	geminiKey := strings.TrimSpace(os.Getenv("GEMINI_API_KEY"))
-	aiEnabled := geminiKey != ""
+	openAIKey := strings.TrimSpace(os.Getenv("OPENAI_API_KEY"))
+	aiEnabled := geminiKey != "" || openAIKey != ""
 	if raw := strings.TrimSpace(os.Getenv("AI_ENABLED")); raw != "" {
 		aiEnabled = strings.EqualFold(raw, "true")
 	}
+	var aiTemperature *float64
+	if raw := strings.TrimSpace(os.Getenv("AI_TEMPERATURE")); raw != "" {
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil || f < 0 {
+			return Config{}, errors.New("AI_TEMPERATURE must be a non-negative number")
+		}
+		aiTemperature = &f
+	}
+	aiMaxOutputTokens := 0
+	if raw := strings.TrimSpace(os.Getenv("AI_MAX_OUTPUT_TOKENS")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return Config{}, errors.New("AI_MAX_OUTPUT_TOKENS must be a positive integer")
+		}
+		aiMaxOutputTokens = n
+	}
+	aiSystemInstruction := strings.TrimSpace(os.Getenv("AI_SYSTEM_INSTRUCTION"))
+	if path := strings.TrimSpace(os.Getenv("AI_SYSTEM_INSTRUCTION_FILE")); aiSystemInstruction == "" && path != "" {
+		b, err := os.ReadFile(filepath.Clean(path))
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to read AI_SYSTEM_INSTRUCTION_FILE: %w", err)
+		}
+		aiSystemInstruction = strings.TrimSpace(string(b))
+	}
+	aiProvider := strings.ToLower(envOr("AI_PROVIDER", "gemini"))
+	defaultModel := "gemini-3-flash-preview"
+	if aiProvider == "openai" {
+		defaultModel = "gpt-4o-mini"
+	}
 
 	return Config{
 		CMCAPIKey:                cmc,
@@ -116,11 +214,22 @@ func ConfigFromEnv(dryRun bool, skipMongo bool) (Config, error) {
 		MongoDBStateCollection:   envOr("MONGODB_STATE_COLLECTION", "state"),
 		MongoDBCoinsCollection:   envOr("MONGODB_COINS_COLLECTION", "coins"),
 		MongoDBHistoryCollection: envOr("MONGODB_HISTORY_COLLECTION", "history"),
+		MongoDBLogosCollection:   envOr("MONGODB_LOGOS_COLLECTION", "logos"),
+		LogoCacheTTL:             time.Duration(logoCacheTTLHours) * time.Hour,
 		TopN:                     topN,
 		AIEnabled:                aiEnabled,
-		AIProvider:               envOr("AI_PROVIDER", "gemini"),
-		AIModel:                  envOr("AI_MODEL", "gemini-3-flash-preview"),
+		AIProvider:               aiProvider,
+		AIModel:                  envOr("AI_MODEL", defaultModel),
 		GeminiAPIKey:             geminiKey,
+		OpenAIAPIKey:             openAIKey,
+		AITemperature:            aiTemperature,
+		AIMaxOutputTokens:        aiMaxOutputTokens,
+		AISystemInstruction:      aiSystemInstruction,
+		CMCMaxRetries:            cmcMaxRetries,
+		CMCRetryBase:             time.Duration(cmcRetryBaseMS) * time.Millisecond,
+		RankJumpThreshold:        rankJumpThreshold,
+		DedupWindow:              time.Duration(dedupWindowMinutes) * time.Minute,
+		HysteresisMargin:         hysteresisMargin,
 	}, nil
 }
 
@@ -132,14 +241,26 @@ func envOr(name, def string) string {
 }
 
 type Coin struct {
-	ID                int64      `bson:"id" json:"id"`
-	Name              string     `bson:"name" json:"name"`
-	Symbol            string     `bson:"symbol" json:"symbol"`
-	Rank              int64      `bson:"rank" json:"rank"`
-	TickTimestamp     *time.Time `bson:"tick_timestamp,omitempty" json:"tick_timestamp,omitempty"`
-	MarketCap         *float64   `bson:"market_cap,omitempty" json:"market_cap,omitempty"`
-	MarketCapCurrency string     `bson:"market_cap_currency" json:"market_cap_currency"`
-	ImageURL          string     `bson:"image_url,omitempty" json:"image_url,omitempty"`
+	ID                int64              `bson:"id" json:"id"`
+	Name              string             `bson:"name" json:"name"`
+	Symbol            string             `bson:"symbol" json:"symbol"`
+	Slug              string             `bson:"slug,omitempty" json:"slug,omitempty"`
+	Rank              int64              `bson:"rank" json:"rank"`
+	TickTimestamp     *time.Time         `bson:"tick_timestamp,omitempty" json:"tick_timestamp,omitempty"`
+	MarketCap         *float64           `bson:"market_cap,omitempty" json:"market_cap,omitempty"`
+	MarketCapCurrency string             `bson:"market_cap_currency" json:"market_cap_currency"`
+	MarketCaps        map[string]float64 `bson:"market_caps,omitempty" json:"market_caps,omitempty"`
+	Price             *float64           `bson:"price,omitempty" json:"price,omitempty"`
+	PercentChange24h  *float64           `bson:"percent_change_24h,omitempty" json:"percent_change_24h,omitempty"`
+	ImageURL          string             `bson:"image_url,omitempty" json:"image_url,omitempty"`
+}
+
+// MovedCoin is a coin that stayed in the top list but changed rank.
+// RankDelta is positive when the coin climbed (prev rank - current rank).
+type MovedCoin struct {
+	Coin
+	PrevRank  int64 `json:"prev_rank"`
+	RankDelta int64 `json:"rank_delta"`
 }
 
 type RecentPost struct {
@@ -161,15 +282,20 @@ type stateCoinDoc struct {
 	ID            int64     `bson:"id"`
 	Name          string    `bson:"name"`
 	Symbol        string    `bson:"symbol"`
+	Slug          string    `bson:"slug,omitempty"`
 	Rank          int64     `bson:"rank"`
 	TickTimestamp time.Time `bson:"tick_timestamp"`
 	Updated       time.Time `bson:"updated_at"`
 	Created       time.Time `bson:"created_at,omitempty"`
 	IsActive      bool      `bson:"is_active"`
-
-	MarketCap         *float64 `bson:"market_cap,omitempty"`
-	MarketCapCurrency string   `bson:"market_cap_currency"`
-	ImageURL          string   `bson:"image_url,omitempty"`
+	Reported      bool      `bson:"reported"`
+
+	MarketCap         *float64           `bson:"market_cap,omitempty"`
+	MarketCapCurrency string             `bson:"market_cap_currency"`
+	MarketCaps        map[string]float64 `bson:"market_caps,omitempty"`
+	Price             *float64           `bson:"price,omitempty"`
+	PercentChange24h  *float64           `bson:"percent_change_24h,omitempty"`
+	ImageURL          string             `bson:"image_url,omitempty"`
 }
 
 type historyDoc struct {
@@ -180,6 +306,7 @@ type historyDoc struct {
 	Text              string    `bson:"text"`
 	MentionedCoins    []Coin    `bson:"mentioned_coins"`
 	TelegramMessageID *int64    `bson:"telegram_message_id,omitempty"`
+	DedupKey          string    `bson:"dedup_key,omitempty"`
 }
 
 func RunOnce(ctx context.Context, cfg Config, opt RunOptions) error {
@@ -201,65 +328,179 @@ func RunOnce(ctx context.Context, cfg Config, opt RunOptions) error {
 	defer client.Disconnect(context.Background())
 	log.Printf("[RunOnce] connected to MongoDB database=%s", cfg.MongoDBDatabase)
 
+	stateCollection := db.Collection(cfg.MongoDBStateCollection)
+	coinsCollection := db.Collection(cfg.MongoDBCoinsCollection)
+	historyCollection := db.Collection(cfg.MongoDBHistoryCollection)
+	logosCollection := db.Collection(cfg.MongoDBLogosCollection)
+	log.Printf("[RunOnce] using collections: state=%s coins=%s history=%s logos=%s", cfg.MongoDBStateCollection, cfg.MongoDBCoinsCollection, cfg.MongoDBHistoryCollection, cfg.MongoDBLogosCollection)
+
+	diff, err := detectTick(ctx, httpClient, cfg, opt, stateCollection, coinsCollection, historyCollection, logosCollection)
+	if err != nil {
+		return err
+	}
+	if diff.baseline {
+		log.Printf("[RunOnce] previous state not found; writing baseline and exiting without Telegram post")
+		return writeState(ctx, stateCollection, coinsCollection, cfg.TopN, primaryCurrency(opt.Convert), diff.fetched, coinIDSet(diff.current))
+	}
+	if diff.renderCtx == nil {
+		log.Printf("[RunOnce] no new coins found; exiting without Telegram post")
+		return nil
+	}
+	newCoins, movedCoins := diff.newCoins, diff.movedCoins
+
+	newIDs := make([]int64, 0, len(newCoins))
+	for _, c := range newCoins {
+		newIDs = append(newIDs, c.ID)
+	}
+
+	log.Printf("[RunOnce] step 8/11: producing Telegram text")
+	text, err := produceTelegramText(ctx, httpClient, cfg, diff.renderCtx)
+	if err != nil {
+		log.Printf("[RunOnce] failed to produce Telegram text: %v", err)
+		return err
+	}
+	log.Printf("[RunOnce] produced Telegram text with %d characters", len(text))
+
+	if opt.DryRun {
+		log.Printf("[RunOnce] step 9/11: dry-run enabled; printing message and exiting")
+		fmt.Println(text)
+		return nil
+	}
+
+	log.Printf("[RunOnce] step 10/11: sending Telegram message")
+	mentionedCoins := newCoins
+	for _, m := range movedCoins {
+		mentionedCoins = append(mentionedCoins, m.Coin)
+	}
+	// Checked right before sending so a concurrent run that finished while
+	// this one was waiting on the AI is still caught.
+	key := dedupKey(newIDs, diff.prev.UpdatedAt)
+	duplicate, err := findRecentDuplicate(ctx, historyCollection, key, cfg.DedupWindow, time.Now().UTC())
+	if err != nil {
+		log.Printf("[RunOnce] failed to check history for duplicates: %v", err)
+		return err
+	}
+	if duplicate {
+		log.Printf("[RunOnce] post with dedup_key=%s already published within %s; skipping Telegram post", key, cfg.DedupWindow)
+		return nil
+	}
+	msgID, err := sendTelegramMessage(ctx, httpClient, cfg, text, firstCoinImageURL(mentionedCoins))
+	if err != nil {
+		log.Printf("[RunOnce] failed to send Telegram message: %v", err)
+		return err
+	}
+	if msgID != nil {
+		log.Printf("[RunOnce] Telegram message sent successfully: message_id=%d", *msgID)
+	} else {
+		log.Printf("[RunOnce] Telegram message sent successfully: message_id is unavailable")
+	}
+
+	log.Printf("[RunOnce] step 11/11: persisting state and writing history")
+	if err := writeState(ctx, stateCollection, coinsCollection, cfg.TopN, primaryCurrency(opt.Convert), diff.fetched, diff.reported); err != nil {
+		log.Printf("[RunOnce] failed to write state: %v", err)
+		return err
+	}
+	_, err = historyCollection.InsertOne(ctx, historyDoc{
+		CreatedAt: time.Now().UTC(), TopN: int64(cfg.TopN), Convert: primaryCurrency(opt.Convert),
+		NewCoinIDs: newIDs, Text: text, MentionedCoins: mentionedCoins, TelegramMessageID: msgID,
+		DedupKey: key,
+	})
+	if err != nil {
+		log.Printf("[RunOnce] failed to append history: %v", err)
+		return err
+	}
+	log.Printf("[RunOnce] completed successfully")
+	return err
+}
+
+// checkFetchedCount rejects listings shorter than half of topN, which point
+// to a partial CMC outage; diffing or storing them would wipe the real list.
+func checkFetchedCount(fetched, topN int) error {
+	if fetched*2 < topN {
+		return fmt.Errorf("cmc returned %d coins for top-%d; expected at least %d", fetched, topN, (topN+1)/2)
+	}
+	return nil
+}
+
+// tickDiff is the outcome of comparing a fresh CoinMarketCap fetch against
+// the stored state. renderCtx is nil when there is nothing to post.
+type tickDiff struct {
+	fetched     []Coin
+	current     []Coin
+	prev        stateDoc
+	baseline    bool
+	newCoins    []Coin
+	exitedCoins []Coin
+	movedCoins  []MovedCoin
+	reported    map[int64]bool
+	renderCtx   map[string]any
+}
+
+// detectTick runs the detection part of a tick: fetch, load previous state,
+// diff and build the render context. It never posts to Telegram or writes
+// state or history; the only write is refreshing the logos cache.
+func detectTick(ctx context.Context, httpClient *http.Client, cfg Config, opt RunOptions, stateCollection, coinsCollection, historyCollection collectionReader, logosCollection logoStore) (*tickDiff, error) {
 	log.Printf("[RunOnce] step 3/11: fetching current top-%d from CoinMarketCap", cfg.TopN)
-	current, err := fetchCMCTopN(ctx, httpClient, cfg, opt)
+	margin := cfg.HysteresisMargin
+	fetchCfg := cfg
+	fetchCfg.TopN = cfg.TopN + margin
+	fetched, err := fetchCMCTopN(ctx, httpClient, fetchCfg, opt, logosCollection)
 	if err != nil {
 		log.Printf("[RunOnce] failed to fetch CoinMarketCap listings: %v", err)
-		return err
+		return nil, err
+	}
+	if err := checkFetchedCount(len(fetched), cfg.TopN); err != nil {
+		log.Printf("[RunOnce] aborting tick without touching state: %v", err)
+		return nil, err
+	}
+	current := fetched
+	if len(current) > cfg.TopN {
+		current = current[:cfg.TopN]
 	}
-	log.Printf("[RunOnce] fetched %d current coins", len(current))
+	log.Printf("[RunOnce] fetched %d current coins (%d including hysteresis margin)", len(current), len(fetched))
 	log.Printf("Incoming top %d %v", cfg.TopN, coinSymbols(current))
-
-	stateCollection := db.Collection(cfg.MongoDBStateCollection)
-	coinsCollection := db.Collection(cfg.MongoDBCoinsCollection)
-	historyCollection := db.Collection(cfg.MongoDBHistoryCollection)
-	log.Printf("[RunOnce] using collections: state=%s coins=%s history=%s", cfg.MongoDBStateCollection, cfg.MongoDBCoinsCollection, cfg.MongoDBHistoryCollection)
+	diff := &tickDiff{fetched: fetched, current: current}
 
 	log.Printf("[RunOnce] step 4/11: loading previous state snapshot")
 	var prev stateDoc
 	err = stateCollection.FindOne(ctx, bson.M{"_id": "top"}).Decode(&prev)
 	if errors.Is(err, mongo.ErrNoDocuments) {
-		log.Printf("[RunOnce] previous state not found; writing baseline and exiting without Telegram post")
-		return writeState(ctx, stateCollection, coinsCollection, cfg.TopN, opt.Convert, current)
+		diff.baseline = true
+		return diff, nil
 	}
 	if err != nil {
 		log.Printf("[RunOnce] failed to load previous state: %v", err)
-		return err
+		return nil, err
 	}
 	log.Printf("[RunOnce] loaded previous state with %d ids", len(prev.IDs))
+	diff.prev = prev
 	prevCoins, err := loadStateCoins(ctx, coinsCollection, "top")
 	if err != nil {
 		log.Printf("[RunOnce] failed to load state coins: %v", err)
-		return err
+		return nil, err
 	}
 	log.Printf("From DB top %d %v", cfg.TopN, coinSymbols(prevCoins))
 
 	log.Printf("[RunOnce] step 5/11: calculating diff between previous and current top lists")
-	prevSet := map[int64]struct{}{}
-	for _, id := range prev.IDs {
-		prevSet[id] = struct{}{}
-	}
-	currentSet := map[int64]struct{}{}
-	for _, c := range current {
-		currentSet[c.ID] = struct{}{}
+	flagged, err := loadReportedIDs(ctx, coinsCollection, "top")
+	if err != nil {
+		log.Printf("[RunOnce] failed to load reported coins: %v", err)
+		return nil, err
 	}
+	prevReported := previousReported(prev.IDs, flagged)
+	fetchedSet := coinIDSet(fetched)
 
-	newCoins := make([]Coin, 0)
-	for _, c := range current {
-		if _, ok := prevSet[c.ID]; !ok {
-			newCoins = append(newCoins, c)
-		}
-	}
-	if len(newCoins) == 0 {
-		log.Printf("[RunOnce] no new coins found; exiting without Telegram post")
-		return nil
+	newCoins, movedCoins, reported := diffCoins(fetched, prevCoins, prevReported, cfg.TopN, margin, cfg.RankJumpThreshold)
+	diff.newCoins, diff.movedCoins, diff.reported = newCoins, movedCoins, reported
+	if len(newCoins) == 0 && len(movedCoins) == 0 {
+		return diff, nil
 	}
-	log.Printf("[RunOnce] detected %d new coin(s)", len(newCoins))
+	log.Printf("[RunOnce] detected %d new coin(s) and %d rank jump(s)", len(newCoins), len(movedCoins))
 
 	exitedCoins := []Coin{}
 	if opt.NotifyExits {
 		for _, c := range prevCoins {
-			if _, ok := currentSet[c.ID]; !ok {
+			if prevReported[c.ID] && !fetchedSet[c.ID] {
 				exitedCoins = append(exitedCoins, c)
 			}
 		}
@@ -269,61 +510,164 @@ func RunOnce(ctx context.Context, cfg Config, opt RunOptions) error {
 	}
 
 	log.Printf("[RunOnce] step 6/11: loading recent posts from history")
-	recentPosts, err := loadRecentPosts(ctx, historyCollection)
+	recentPosts, err := loadRecentPosts(ctx, historyCollection, defaultRecentPostsLimit)
 	if err != nil {
 		log.Printf("[RunOnce] failed to load recent posts: %v", err)
-		return err
+		return nil, err
 	}
 	log.Printf("[RunOnce] loaded %d recent post(s)", len(recentPosts))
 
 	log.Printf("[RunOnce] step 7/11: building render context")
-	renderCtx := buildRenderContext(cfg, opt, newCoins, exitedCoins, recentPosts)
+	diff.exitedCoins = exitedCoins
+	diff.renderCtx = buildRenderContext(cfg, opt, newCoins, exitedCoins, movedCoins, recentPosts)
 
-	log.Printf("[RunOnce] step 8/11: producing Telegram text")
-	text, err := produceTelegramText(ctx, httpClient, cfg, renderCtx)
-	if err != nil {
-		log.Printf("[RunOnce] failed to produce Telegram text: %v", err)
-		return err
+	return diff, nil
+}
+
+// previousReported returns the coins already announced in the stored state.
+// The per-coin reported flags win; state written before they existed only
+// has ids, and those are all treated as announced.
+func previousReported(ids []int64, flagged map[int64]bool) map[int64]bool {
+	if len(flagged) > 0 {
+		return flagged
 	}
-	log.Printf("[RunOnce] produced Telegram text with %d characters", len(text))
+	out := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		out[id] = true
+	}
+	return out
+}
 
-	if opt.DryRun {
-		log.Printf("[RunOnce] step 9/11: dry-run enabled; printing message and exiting")
-		fmt.Println(text)
-		return nil
+// diffCoins compares fetched (top-N plus margin buffer) against the stored
+// coins. Rank jumps are computed only over previously reported coins, so a
+// buffer coin that is announced as new this tick is not also listed as moved.
+func diffCoins(fetched, prevCoins []Coin, prevReported map[int64]bool, topN, margin, threshold int) ([]Coin, []MovedCoin, map[int64]bool) {
+	current := fetched
+	if len(current) > topN {
+		current = current[:topN]
+	}
+	newCoins, reported := detectNewCoins(fetched, topN, margin, prevReported)
+	announced := make([]Coin, 0, len(prevCoins))
+	for _, c := range prevCoins {
+		if prevReported[c.ID] {
+			announced = append(announced, c)
+		}
 	}
+	return newCoins, computeRankMoves(announced, current, threshold), reported
+}
 
-	log.Printf("[RunOnce] step 10/11: sending Telegram message")
-	msgID, err := sendTelegramMessage(ctx, httpClient, cfg, text, firstCoinImageURL(newCoins))
-	if err != nil {
-		log.Printf("[RunOnce] failed to send Telegram message: %v", err)
-		return err
+// detectNewCoins returns the coins to announce as new and the updated set of
+// reported coins. fetched holds the top-N plus margin buffer coins. A coin is
+// announced only once it sits at least margin positions inside the boundary,
+// and stays reported while it remains anywhere in fetched, so a coin bouncing
+// around the boundary is not re-announced. margin 0 is a plain set diff.
+func detectNewCoins(fetched []Coin, topN, margin int, prevReported map[int64]bool) ([]Coin, map[int64]bool) {
+	newCoins := make([]Coin, 0)
+	reported := map[int64]bool{}
+	for i, c := range fetched {
+		if prevReported[c.ID] {
+			reported[c.ID] = true
+			continue
+		}
+		if i < topN-margin {
+			newCoins = append(newCoins, c)
+			reported[c.ID] = true
+		}
 	}
-	if msgID != nil {
-		log.Printf("[RunOnce] Telegram message sent successfully: message_id=%d", *msgID)
-	} else {
-		log.Printf("[RunOnce] Telegram message sent successfully: message_id is unavailable")
+	return newCoins, reported
+}
+
+func coinIDSet(coins []Coin) map[int64]bool {
+	out := make(map[int64]bool, len(coins))
+	for _, c := range coins {
+		out[c.ID] = true
 	}
+	return out
+}
 
-	log.Printf("[RunOnce] step 11/11: persisting state and writing history")
-	if err := writeState(ctx, stateCollection, coinsCollection, cfg.TopN, opt.Convert, current); err != nil {
-		log.Printf("[RunOnce] failed to write state: %v", err)
-		return err
+// dedupKey identifies a post by the (order-independent) set of new coin IDs
+// and the UpdatedAt of the state snapshot it was diffed against.
+func dedupKey(newIDs []int64, stateUpdatedAt time.Time) string {
+	ids := append([]int64(nil), newIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, strconv.FormatInt(id, 10))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, ",") + "@" + stateUpdatedAt.UTC().Format(time.RFC3339Nano)))
+	return hex.EncodeToString(sum[:])
+}
+
+type historyFinder interface {
+	FindOne(ctx context.Context, filter any, opts ...*options.FindOneOptions) *mongo.SingleResult
+}
+
+// collectionReader is the read-only part of *mongo.Collection that tick
+// detection needs; it has no write methods, so detection can't touch state.
+type collectionReader interface {
+	historyFinder
+	Find(ctx context.Context, filter any, opts ...*options.FindOptions) (*mongo.Cursor, error)
+}
+
+// findRecentDuplicate reports whether a history doc with key was created
+// within window before now. A zero window disables the check.
+func findRecentDuplicate(ctx context.Context, history historyFinder, key string, window time.Duration, now time.Time) (bool, error) {
+	if window <= 0 || key == "" {
+		return false, nil
 	}
-	newIDs := make([]int64, 0, len(newCoins))
-	for _, c := range newCoins {
-		newIDs = append(newIDs, c.ID)
+	err := history.FindOne(ctx, bson.M{"dedup_key": key, "created_at": bson.M{"$gte": now.Add(-window)}}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
 	}
-	_, err = historyCollection.InsertOne(ctx, historyDoc{
-		CreatedAt: time.Now().UTC(), TopN: int64(cfg.TopN), Convert: opt.Convert,
-		NewCoinIDs: newIDs, Text: text, MentionedCoins: newCoins, TelegramMessageID: msgID,
-	})
 	if err != nil {
-		log.Printf("[RunOnce] failed to append history: %v", err)
-		return err
+		return false, err
 	}
-	log.Printf("[RunOnce] completed successfully")
-	return err
+	return true, nil
+}
+
+// TickPreview is the dry-run result of a tick: the detected diff and the text
+// that would be posted, without touching Telegram or the stored state.
+type TickPreview struct {
+	NewCoins    []Coin      `json:"new_coins"`
+	ExitedCoins []Coin      `json:"exited_coins"`
+	MovedCoins  []MovedCoin `json:"moved_coins"`
+	TopN        int         `json:"top_n"`
+	Convert     string      `json:"convert"`
+	Text        string      `json:"text"`
+}
+
+// PreviewTick runs tick detection against the stored state and renders the
+// post text without sending it to Telegram or writing state or history.
+// It returns ErrNoState before the first baseline is written.
+func PreviewTick(ctx context.Context, cfg Config, opt RunOptions) (TickPreview, error) {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	db, client, err := connectDB(ctx, cfg)
+	if err != nil {
+		return TickPreview{}, err
+	}
+	defer client.Disconnect(context.Background())
+	return previewTick(ctx, httpClient, cfg, opt, db.Collection(cfg.MongoDBStateCollection), db.Collection(cfg.MongoDBCoinsCollection), db.Collection(cfg.MongoDBHistoryCollection), db.Collection(cfg.MongoDBLogosCollection))
+}
+
+func previewTick(ctx context.Context, httpClient *http.Client, cfg Config, opt RunOptions, stateCollection, coinsCollection, historyCollection collectionReader, logosCollection logoStore) (TickPreview, error) {
+	diff, err := detectTick(ctx, httpClient, cfg, opt, stateCollection, coinsCollection, historyCollection, logosCollection)
+	if err != nil {
+		return TickPreview{}, err
+	}
+	if diff.baseline {
+		return TickPreview{}, ErrNoState
+	}
+	preview := TickPreview{NewCoins: diff.newCoins, ExitedCoins: diff.exitedCoins, MovedCoins: diff.movedCoins, TopN: cfg.TopN, Convert: primaryCurrency(opt.Convert)}
+	if preview.ExitedCoins == nil {
+		preview.ExitedCoins = []Coin{}
+	}
+	if diff.renderCtx != nil {
+		preview.Text, err = produceTelegramText(ctx, httpClient, cfg, diff.renderCtx)
+		if err != nil {
+			return TickPreview{}, err
+		}
+	}
+	return preview, nil
 }
 
 func runWithoutMongo(ctx context.Context, httpClient *http.Client, cfg Config, opt RunOptions) error {
@@ -343,7 +687,7 @@ func runWithoutMongo(ctx context.Context, httpClient *http.Client, cfg Config, o
 		return nil
 	}
 
-	current, err := fetchCMCTopN(ctx, httpClient, cfg, opt)
+	current, err := fetchCMCTopN(ctx, httpClient, cfg, opt, nil)
 	if err != nil {
 		return err
 	}
@@ -356,7 +700,7 @@ func runWithoutMongo(ctx context.Context, httpClient *http.Client, cfg Config, o
 		newCount = 3
 	}
 	newCoins := current[:newCount]
-	renderCtx := buildRenderContext(cfg, opt, newCoins, []Coin{}, []RecentPost{})
+	renderCtx := buildRenderContext(cfg, opt, newCoins, []Coin{}, []MovedCoin{}, []RecentPost{})
 	text, err := produceTelegramText(ctx, httpClient, cfg, renderCtx)
 	if err != nil {
 		return err
@@ -383,12 +727,35 @@ func connectDB(ctx context.Context, cfg Config) (*mongo.Database, *mongo.Client,
 	return client.Database(cfg.MongoDBDatabase), client, nil
 }
 
-func fetchCMCTopN(ctx context.Context, client *http.Client, cfg Config, opt RunOptions) ([]Coin, error) {
+// convertCurrencies splits a comma-separated convert option such as "USD,EUR"
+// into upper-cased currency codes, defaulting to USD.
+func convertCurrencies(convert string) []string {
+	out := []string{}
+	for _, c := range strings.Split(convert, ",") {
+		if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+			out = append(out, c)
+		}
+	}
+	if len(out) == 0 {
+		out = append(out, "USD")
+	}
+	return out
+}
+
+// primaryCurrency is the first convert currency; it backs MarketCap and the
+// convert field stored on state and history.
+func primaryCurrency(convert string) string { return convertCurrencies(convert)[0] }
+
+// fetchCMCTopN loads the listings and attaches logos. logoCache may be nil,
+// in which case every logo is requested from CoinMarketCap.
+func fetchCMCTopN(ctx context.Context, client *http.Client, cfg Config, opt RunOptions, logoCache logoStore) ([]Coin, error) {
 	now := time.Now().UTC()
-	u := fmt.Sprintf("https://pro-api.coinmarketcap.com/v1/cryptocurrency/listings/latest?start=1&limit=%d&convert=%s&sort=market_cap&sort_dir=desc", cfg.TopN, url.QueryEscape(opt.Convert))
+	currencies := convertCurrencies(opt.Convert)
+	primary := currencies[0]
+	u := fmt.Sprintf("https://pro-api.coinmarketcap.com/v1/cryptocurrency/listings/latest?start=1&limit=%d&convert=%s&sort=market_cap&sort_dir=desc", cfg.TopN, url.QueryEscape(strings.Join(currencies, ",")))
 	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	req.Header.Set("X-CMC_PRO_API_KEY", cfg.CMCAPIKey)
-	resp, err := client.Do(req)
+	resp, err := doWithRetry(ctx, client, req, cfg.CMCMaxRetries+1, cfg.CMCRetryBase)
 	if err != nil {
 		return nil, err
 	}
@@ -401,24 +768,49 @@ func fetchCMCTopN(ctx context.Context, client *http.Client, cfg Config, opt RunO
 	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
 		return nil, err
 	}
-	data, _ := payload["data"].([]any)
+	if err := cmcStatusError(payload); err != nil {
+		return nil, err
+	}
+	data, ok := payload["data"].([]any)
+	if !ok {
+		return nil, errors.New("cmc error: listings response has no data array")
+	}
 	coins := make([]Coin, 0, len(data))
 	for _, item := range data {
 		m, _ := item.(map[string]any)
-		coin := Coin{ID: asInt64(m["id"]), Name: asStringDef(m["name"], "Unknown"), Symbol: asStringDef(m["symbol"], "???"), Rank: asInt64(m["cmc_rank"]), TickTimestamp: &now, MarketCapCurrency: opt.Convert}
+		coin := Coin{ID: asInt64(m["id"]), Name: asStringDef(m["name"], "Unknown"), Symbol: asStringDef(m["symbol"], "???"), Slug: asString(m["slug"]), Rank: asInt64(m["cmc_rank"]), TickTimestamp: &now, MarketCapCurrency: primary}
 		if quote, ok := m["quote"].(map[string]any); ok {
-			if curr, ok := quote[opt.Convert].(map[string]any); ok {
-				if mc, ok := asFloat(curr["market_cap"]); ok {
+			for _, currency := range currencies {
+				curr, ok := quote[currency].(map[string]any)
+				if !ok {
+					continue
+				}
+				mc, ok := asFloat(curr["market_cap"])
+				if !ok {
+					continue
+				}
+				if coin.MarketCaps == nil {
+					coin.MarketCaps = map[string]float64{}
+				}
+				coin.MarketCaps[currency] = mc
+				if currency == primary {
 					coin.MarketCap = &mc
 				}
 			}
+			if curr, ok := quote[primary].(map[string]any); ok {
+				if price, ok := asFloat(curr["price"]); ok {
+					coin.Price = &price
+				}
+				if change, ok := asFloat(curr["percent_change_24h"]); ok {
+					coin.PercentChange24h = &change
+				}
+			}
 		}
 		coins = append(coins, coin)
 	}
-	logos, err := fetchCMCLogos(ctx, client, cfg, coins)
+	logos, err := fetchCMCLogos(ctx, client, cfg, coins, logoCache)
 	if err != nil {
 		log.Printf("[fetchCMCTopN] unable to fetch coin logos: %v", err)
-		return coins, nil
 	}
 	for i := range coins {
 		if logo := logos[coins[i].ID]; logo != "" {
@@ -428,18 +820,168 @@ func fetchCMCTopN(ctx context.Context, client *http.Client, cfg Config, opt RunO
 	return coins, nil
 }
 
-func fetchCMCLogos(ctx context.Context, client *http.Client, cfg Config, coins []Coin) (map[int64]string, error) {
+// cmcStatusError reports a non-zero status.error_code, which CMC can send
+// even with HTTP 200.
+func cmcStatusError(payload map[string]any) error {
+	status, _ := payload["status"].(map[string]any)
+	if code := asInt64(status["error_code"]); code != 0 {
+		return fmt.Errorf("cmc error: error_code=%d %s", code, asString(status["error_message"]))
+	}
+	return nil
+}
+
+// maxRetryDelay caps a single CMC retry wait, both the backoff and any
+// Retry-After the server asks for, so a tick can't outlive the function timeout.
+const maxRetryDelay = 10 * time.Second
+
+// doWithRetry sends a body-less request, retrying 429 and 5xx responses with
+// exponential backoff plus jitter. A Retry-After header overrides the backoff.
+func doWithRetry(ctx context.Context, client *http.Client, req *http.Request, maxAttempts int, baseDelay time.Duration) (*http.Response, error) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if attempt >= maxAttempts || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500) {
+			return resp, nil
+		}
+		delay := retryAfterDelay(resp.Header.Get("Retry-After"))
+		if delay <= 0 {
+			delay = baseDelay << (attempt - 1)
+			if delay > 0 {
+				delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+			}
+		}
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		log.Printf("[doWithRetry] %s returned %s; retrying in %s (attempt %d/%d)", req.URL.Path, resp.Status, delay, attempt+1, maxAttempts)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func retryAfterDelay(raw string) time.Duration {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0
+	}
+	var delay time.Duration
+	if secs, err := strconv.Atoi(raw); err == nil {
+		delay = time.Duration(secs) * time.Second
+	} else if at, err := http.ParseTime(raw); err == nil {
+		delay = time.Until(at)
+	}
+	return min(delay, maxRetryDelay)
+}
+
+type logoStore interface {
+	Find(ctx context.Context, filter any, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+}
+
+type logoDoc struct {
+	ID        int64     `bson:"_id"`
+	Logo      string    `bson:"logo"`
+	FetchedAt time.Time `bson:"fetched_at"`
+}
+
+// fetchCMCLogos returns logos for coins, serving entries younger than
+// cfg.LogoCacheTTL from cache and requesting only the rest. Ids CMC has no
+// logo for are cached empty so they aren't requested every tick. A nil cache
+// or a zero TTL requests every coin. Cache failures are logged, not returned.
+func fetchCMCLogos(ctx context.Context, client *http.Client, cfg Config, coins []Coin, cache logoStore) (map[int64]string, error) {
 	if len(coins) == 0 {
 		return map[int64]string{}, nil
 	}
-	ids := make([]string, 0, len(coins))
+	ids := make([]int64, 0, len(coins))
 	for _, c := range coins {
-		ids = append(ids, strconv.FormatInt(c.ID, 10))
+		ids = append(ids, c.ID)
+	}
+	if cache == nil || cfg.LogoCacheTTL <= 0 {
+		return requestCMCLogos(ctx, client, cfg, ids)
+	}
+
+	now := time.Now().UTC()
+	out, err := loadCachedLogos(ctx, cache, ids, now.Add(-cfg.LogoCacheTTL))
+	if err != nil {
+		log.Printf("[fetchCMCLogos] unable to read logo cache: %v", err)
+		out = map[int64]string{}
+	}
+	missing := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := out[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return out, nil
+	}
+	log.Printf("[fetchCMCLogos] logo cache hits=%d misses=%d", len(ids)-len(missing), len(missing))
+	fresh, err := requestCMCLogos(ctx, client, cfg, missing)
+	if err != nil {
+		return out, err
+	}
+	for _, id := range missing {
+		if _, ok := fresh[id]; !ok {
+			fresh[id] = ""
+		}
+	}
+	if err := storeCachedLogos(ctx, cache, fresh, now); err != nil {
+		log.Printf("[fetchCMCLogos] unable to update logo cache: %v", err)
+	}
+	for id, logo := range fresh {
+		out[id] = logo
+	}
+	return out, nil
+}
+
+func loadCachedLogos(ctx context.Context, cache logoStore, ids []int64, freshSince time.Time) (map[int64]string, error) {
+	cur, err := cache.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "fetched_at": bson.M{"$gte": freshSince}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := map[int64]string{}
+	for cur.Next(ctx) {
+		var d logoDoc
+		if err := cur.Decode(&d); err != nil {
+			return nil, err
+		}
+		out[d.ID] = d.Logo
+	}
+	return out, cur.Err()
+}
+
+func storeCachedLogos(ctx context.Context, cache logoStore, logos map[int64]string, now time.Time) error {
+	if len(logos) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(logos))
+	for id, logo := range logos {
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": id}).SetReplacement(logoDoc{ID: id, Logo: logo, FetchedAt: now}).SetUpsert(true))
+	}
+	_, err := cache.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func requestCMCLogos(ctx context.Context, client *http.Client, cfg Config, coinIDs []int64) (map[int64]string, error) {
+	ids := make([]string, 0, len(coinIDs))
+	for _, id := range coinIDs {
+		ids = append(ids, strconv.FormatInt(id, 10))
 	}
 	u := fmt.Sprintf("https://pro-api.coinmarketcap.com/v2/cryptocurrency/info?id=%s", strings.Join(ids, ","))
 	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	req.Header.Set("X-CMC_PRO_API_KEY", cfg.CMCAPIKey)
-	resp, err := client.Do(req)
+	resp, err := doWithRetry(ctx, client, req, cfg.CMCMaxRetries+1, cfg.CMCRetryBase)
 	if err != nil {
 		return nil, err
 	}
@@ -452,23 +994,83 @@ func fetchCMCLogos(ctx context.Context, client *http.Client, cfg Config, coins [
 	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
 		return nil, err
 	}
-	out := map[int64]string{}
-	data, _ := payload["data"].(map[string]any)
-	for k, raw := range data {
-		id, err := strconv.ParseInt(k, 10, 64)
-		if err != nil {
-			continue
-		}
-		entry, _ := raw.(map[string]any)
-		if logo := asString(entry["logo"]); logo != "" {
-			out[id] = logo
-		}
+	if err := cmcStatusError(payload); err != nil {
+		return nil, err
+	}
+	out := map[int64]string{}
+	data, _ := payload["data"].(map[string]any)
+	for k, raw := range data {
+		id, err := strconv.ParseInt(k, 10, 64)
+		if err != nil {
+			continue
+		}
+		entry, _ := raw.(map[string]any)
+		if logo := asString(entry["logo"]); logo != "" {
+			out[id] = logo
+		}
+	}
+	return out, nil
+}
+
+const defaultRecentPostsLimit = 3
+
+// LoadRecentPosts connects to MongoDB and returns up to limit published posts,
+// most recent first.
+func LoadRecentPosts(ctx context.Context, cfg Config, limit int) ([]RecentPost, error) {
+	db, client, err := connectDB(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(context.Background())
+	return loadRecentPosts(ctx, db.Collection(cfg.MongoDBHistoryCollection), limit)
+}
+
+// ErrNoState is returned by LoadState before the first baseline is written.
+var ErrNoState = errors.New("no baseline state found")
+
+// TrackedState is the stored Top-N snapshot with its top_n active coins in
+// rank order.
+type TrackedState struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	TopN      int64     `json:"top_n"`
+	Convert   string    `json:"convert"`
+	Coins     []Coin    `json:"coins"`
+}
+
+// LoadState connects to MongoDB and returns the current state snapshot, or
+// ErrNoState when no baseline exists yet.
+func LoadState(ctx context.Context, cfg Config) (TrackedState, error) {
+	db, client, err := connectDB(ctx, cfg)
+	if err != nil {
+		return TrackedState{}, err
+	}
+	defer client.Disconnect(context.Background())
+	return loadTrackedState(ctx, db.Collection(cfg.MongoDBStateCollection), db.Collection(cfg.MongoDBCoinsCollection))
+}
+
+// loadTrackedState trims the stored coins to the state's top_n, dropping the
+// HYSTERESIS_MARGIN buffer coins kept below it.
+func loadTrackedState(ctx context.Context, stateCollection, coinsCollection collectionReader) (TrackedState, error) {
+	var doc stateDoc
+	err := stateCollection.FindOne(ctx, bson.M{"_id": "top"}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return TrackedState{}, ErrNoState
+	}
+	if err != nil {
+		return TrackedState{}, err
+	}
+	coins, err := loadStateCoins(ctx, coinsCollection, "top")
+	if err != nil {
+		return TrackedState{}, err
+	}
+	if doc.TopN > 0 && int64(len(coins)) > doc.TopN {
+		coins = coins[:doc.TopN]
 	}
-	return out, nil
+	return TrackedState{UpdatedAt: doc.UpdatedAt.UTC(), TopN: doc.TopN, Convert: doc.Convert, Coins: coins}, nil
 }
 
-func loadRecentPosts(ctx context.Context, historyCollection *mongo.Collection) ([]RecentPost, error) {
-	cur, err := historyCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(3))
+func loadRecentPosts(ctx context.Context, historyCollection collectionReader, limit int) ([]RecentPost, error) {
+	cur, err := historyCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(int64(limit)))
 	if err != nil {
 		return nil, err
 	}
@@ -484,22 +1086,89 @@ func loadRecentPosts(ctx context.Context, historyCollection *mongo.Collection) (
 	return out, cur.Err()
 }
 
-func buildRenderContext(cfg Config, opt RunOptions, newCoins, exited []Coin, recent []RecentPost) map[string]any {
-	return map[string]any{"project_name": "coinmarketcap_top100_bot", "timestamp_utc": time.Now().UTC().Format(time.RFC3339), "top_n": cfg.TopN, "convert": opt.Convert, "new_coins": newCoins, "exited_coins": exited, "recent_posts": recent}
+// computeRankMoves joins current against prev by ID and returns coins present
+// in both whose rank changed by at least threshold. threshold <= 0 disables it.
+func computeRankMoves(prev, current []Coin, threshold int) []MovedCoin {
+	moved := []MovedCoin{}
+	if threshold <= 0 {
+		return moved
+	}
+	prevRanks := map[int64]int64{}
+	for _, c := range prev {
+		prevRanks[c.ID] = c.Rank
+	}
+	for _, c := range current {
+		prevRank, ok := prevRanks[c.ID]
+		if !ok {
+			continue
+		}
+		delta := prevRank - c.Rank
+		if delta >= int64(threshold) || -delta >= int64(threshold) {
+			moved = append(moved, MovedCoin{Coin: c, PrevRank: prevRank, RankDelta: delta})
+		}
+	}
+	return moved
+}
+
+func buildRenderContext(cfg Config, opt RunOptions, newCoins, exited []Coin, moved []MovedCoin, recent []RecentPost) map[string]any {
+	movedMaps := make([]map[string]any, 0, len(moved))
+	for _, m := range moved {
+		movedMaps = append(movedMaps, withMarketCapKeys(m, m.MarketCaps))
+	}
+	return map[string]any{"project_name": "coinmarketcap_top100_bot", "timestamp_utc": time.Now().UTC().Format(time.RFC3339), "top_n": cfg.TopN, "convert": primaryCurrency(opt.Convert), "currencies": convertCurrencies(opt.Convert), "new_coins": coinRenderMaps(newCoins), "exited_coins": coinRenderMaps(exited), "moved_coins": movedMaps, "recent_posts": recent}
+}
+
+func coinRenderMaps(coins []Coin) []map[string]any {
+	out := make([]map[string]any, 0, len(coins))
+	for _, c := range coins {
+		out = append(out, withMarketCapKeys(c, c.MarketCaps))
+	}
+	return out
+}
+
+// withMarketCapKeys flattens a coin for templates and adds one
+// market_cap_<CURRENCY> key per quoted currency.
+func withMarketCapKeys(v any, caps map[string]float64) map[string]any {
+	m, ok := toMap(v)
+	if !ok {
+		m = map[string]any{}
+	}
+	for currency, mc := range caps {
+		m["market_cap_"+currency] = mc
+	}
+	return m
 }
 
 func produceTelegramText(ctx context.Context, client *http.Client, cfg Config, renderCtx map[string]any) (string, error) {
 	fallback := loadTemplateOrDefault("templates/telegram_post_fallback.template.md", defaultFallbackTemplate)
-	if cfg.AIEnabled && cfg.AIProvider == "gemini" && cfg.GeminiAPIKey != "" {
+	if !cfg.AIEnabled {
+		return RenderTemplate(fallback, renderCtx), nil
+	}
+	var call func(context.Context, *http.Client, Config, string) (string, error)
+	switch cfg.AIProvider {
+	case "gemini":
+		if cfg.GeminiAPIKey != "" {
+			call = callGemini
+		}
+	case "openai":
+		if cfg.OpenAIAPIKey != "" {
+			call = callOpenAI
+		}
+	default:
+		log.Printf("[AI] unknown provider %q; using fallback template", cfg.AIProvider)
+	}
+	if call != nil {
 		prompt := RenderTemplate(loadTemplateOrDefault("prompts/newcoins.prompts.md", defaultPrompt), renderCtx)
-		log.Printf("[Gemini] prompt:\n%s", prompt)
-		text, err := callGemini(ctx, client, cfg, prompt)
+		log.Printf("[AI:%s] prompt:\n%s", cfg.AIProvider, prompt)
+		text, err := call(ctx, client, cfg, prompt)
 		if err == nil {
-			log.Printf("[Gemini] response:\n%s", text)
+			log.Printf("[AI:%s] response:\n%s", cfg.AIProvider, text)
 			clean := sanitizeAIText(text)
 			if clean != "" {
 				return clean, nil
 			}
+		} else {
+			log.Printf("[AI:%s] request failed; using fallback template: %v", cfg.AIProvider, err)
 		}
 	}
 	return RenderTemplate(fallback, renderCtx), nil
@@ -515,8 +1184,7 @@ func loadTemplateOrDefault(path string, fallback string) string {
 
 func callGemini(ctx context.Context, client *http.Client, cfg Config, prompt string) (string, error) {
 	u := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent", cfg.AIModel)
-	payload := map[string]any{"contents": []any{map[string]any{"parts": []any{map[string]any{"text": prompt}}}}}
-	body, _ := json.Marshal(payload)
+	body, _ := json.Marshal(geminiRequestPayload(cfg, prompt))
 	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(string(body)))
 	req.Header.Set("x-goog-api-key", cfg.GeminiAPIKey)
 	req.Header.Set("Content-Type", "application/json")
@@ -547,25 +1215,266 @@ func callGemini(ctx context.Context, client *http.Client, cfg Config, prompt str
 	return strings.TrimSpace(asString(part["text"])), nil
 }
 
+// geminiRequestPayload builds the generateContent body. Generation settings
+// and the system instruction are only included when configured.
+func geminiRequestPayload(cfg Config, prompt string) map[string]any {
+	payload := map[string]any{"contents": []any{map[string]any{"parts": []any{map[string]any{"text": prompt}}}}}
+	genCfg := map[string]any{}
+	if cfg.AITemperature != nil {
+		genCfg["temperature"] = *cfg.AITemperature
+	}
+	if cfg.AIMaxOutputTokens > 0 {
+		genCfg["maxOutputTokens"] = cfg.AIMaxOutputTokens
+	}
+	if len(genCfg) > 0 {
+		payload["generationConfig"] = genCfg
+	}
+	if cfg.AISystemInstruction != "" {
+		payload["system_instruction"] = map[string]any{"parts": []any{map[string]any{"text": cfg.AISystemInstruction}}}
+	}
+	return payload
+}
+
+func callOpenAI(ctx context.Context, client *http.Client, cfg Config, prompt string) (string, error) {
+	u := "https://api.openai.com/v1/chat/completions"
+	payload := map[string]any{"model": cfg.AIModel, "messages": []any{map[string]any{"role": "user", "content": prompt}}}
+	body, _ := json.Marshal(payload)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(string(body)))
+	req.Header.Set("Authorization", "Bearer "+cfg.OpenAIAPIKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("openai error: %s %s", resp.Status, string(b))
+	}
+	var parsed map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return "", err
+	}
+	choices, _ := parsed["choices"].([]any)
+	if len(choices) == 0 {
+		return "", nil
+	}
+	choice, _ := choices[0].(map[string]any)
+	message, _ := choice["message"].(map[string]any)
+	return strings.TrimSpace(asString(message["content"])), nil
+}
+
+const (
+	telegramMessageLimit = 4096
+	telegramCaptionLimit = 1024
+)
+
+// sendTelegramMessage posts text to every chat in the comma-separated
+// TelegramChannelID and returns the message_id of the first successful send.
+// A failing chat is logged; an error is returned only if every chat fails.
 func sendTelegramMessage(ctx context.Context, client *http.Client, cfg Config, text string, imageURL string) (*int64, error) {
+	var firstID *int64
+	var firstErr error
+	sent := false
+	for _, chatID := range telegramChatIDs(cfg.TelegramChannelID) {
+		chatCfg := cfg
+		chatCfg.TelegramChannelID = chatID
+		msgID, err := sendTelegramMessageToChat(ctx, client, chatCfg, text, imageURL)
+		if err != nil {
+			log.Printf("[Telegram] failed to send to chat %s: %v", chatID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if !sent {
+			firstID, sent = msgID, true
+		}
+	}
+	if !sent {
+		if firstErr == nil {
+			firstErr = errors.New("no telegram chat id configured")
+		}
+		return nil, firstErr
+	}
+	return firstID, nil
+}
+
+func telegramChatIDs(raw string) []string {
+	ids := []string{}
+	for _, id := range strings.Split(raw, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func sendTelegramMessageToChat(ctx context.Context, client *http.Client, cfg Config, text string, imageURL string) (*int64, error) {
 	formattedText := formatTelegramHTML(text)
 
 	if imageURL != "" {
-		if msgID, err := sendTelegramPhoto(ctx, client, cfg, imageURL, text); err == nil {
+		caption := formattedText
+		rest := []string{}
+		if len([]rune(formattedText)) > telegramMessageLimit {
+			parts := splitTelegramText(formattedText, telegramCaptionLimit, telegramMessageLimit)
+			caption, rest = parts[0], parts[1:]
+		}
+		if msgID, err := sendTelegramPhotoFormatted(ctx, client, cfg, imageURL, caption); err == nil {
+			sendTelegramFollowUps(ctx, client, cfg, rest)
 			return msgID, nil
 		}
 	}
 
-	return sendTelegramMessageFormatted(ctx, client, cfg, formattedText)
+	chunks := splitTelegramText(formattedText, telegramMessageLimit, telegramMessageLimit)
+	msgID, err := sendTelegramMessageFormatted(ctx, client, cfg, chunks[0])
+	if err != nil {
+		return nil, err
+	}
+	sendTelegramFollowUps(ctx, client, cfg, chunks[1:])
+	return msgID, nil
+}
+
+// sendTelegramFollowUps sends the remaining chunks of a split post. The first
+// chunk is already published, so failures are logged instead of returned to
+// avoid re-posting the whole message on the next tick.
+func sendTelegramFollowUps(ctx context.Context, client *http.Client, cfg Config, chunks []string) {
+	for i, chunk := range chunks {
+		if _, err := sendTelegramMessageFormatted(ctx, client, cfg, chunk); err != nil {
+			log.Printf("[Telegram] failed to send follow-up chunk %d/%d: %v", i+1, len(chunks), err)
+			return
+		}
+	}
+}
+
+// splitTelegramText splits already formatted HTML text into chunks of at most
+// firstLimit runes for the first chunk and limit runes for the rest. It prefers
+// newline boundaries and never cuts through a tag, an entity or a <b> span.
+func splitTelegramText(text string, firstLimit, limit int) []string {
+	runes := []rune(text)
+	chunks := []string{}
+	chunkLimit := firstLimit
+	for len(runes) > chunkLimit {
+		cut := telegramCutIndex(runes, chunkLimit-len("</a></b>"))
+		chunk := strings.TrimRight(string(runes[:cut]), "\n")
+		rest := strings.TrimLeft(string(runes[cut:]), "\n")
+		// Only reached when a single span is longer than the limit.
+		if i := unclosedAnchorStart(chunk); i >= 0 {
+			openTag := chunk[i : i+strings.Index(chunk[i:], ">")+1]
+			chunk += "</a>"
+			rest = openTag + rest
+		}
+		if unclosedBoldStart(chunk) >= 0 {
+			chunk += "</b>"
+			rest = "<b>" + rest
+		}
+		chunks = append(chunks, chunk)
+		runes = []rune(rest)
+		chunkLimit = limit
+	}
+	return append(chunks, string(runes))
+}
+
+func telegramCutIndex(runes []rune, limit int) int {
+	window := string(runes[:limit])
+	cut := limit
+	if i := strings.LastIndex(window, "\n"); i > 0 {
+		cut = utf8.RuneCountInString(window[:i+1])
+	} else if i := strings.LastIndex(window, " "); i > 0 {
+		cut = utf8.RuneCountInString(window[:i+1])
+	}
+	head := string(runes[:cut])
+	if i := strings.LastIndex(head, "<"); i >= 0 && !strings.Contains(head[i:], ">") {
+		head = head[:i]
+	}
+	if i := strings.LastIndex(head, "&"); i > 0 && !strings.Contains(head[i:], ";") {
+		head = head[:i]
+	}
+	// A bare "<b>" before the anchor means the bold span itself is too long;
+	// keep the cut and let splitTelegramText close and reopen both tags.
+	if i := unclosedAnchorStart(head); i > 0 && head[:i] != "<b>" {
+		head = head[:i]
+	}
+	if i := unclosedBoldStart(head); i > 0 {
+		head = head[:i]
+	}
+	if head == "" {
+		return limit
+	}
+	return utf8.RuneCountInString(head)
+}
+
+// unclosedBoldStart returns the byte offset of a trailing <b> that has no
+// matching </b>, or -1.
+func unclosedBoldStart(s string) int {
+	open := strings.LastIndex(s, "<b>")
+	if open > strings.LastIndex(s, "</b>") {
+		return open
+	}
+	return -1
+}
+
+// unclosedAnchorStart returns the byte offset of a trailing <a ...> that has
+// no matching </a>, or -1.
+func unclosedAnchorStart(s string) int {
+	open := strings.LastIndex(s, "<a ")
+	if open > strings.LastIndex(s, "</a>") {
+		return open
+	}
+	return -1
+}
+
+const telegramMaxRetries = 3
+
+// telegramMaxRetryAfter is the longest retry_after (in seconds) worth waiting
+// for; a longer one fails the send instead of sleeping past the timeout.
+const telegramMaxRetryAfter = 10
+
+// telegramRetryUnit scales Telegram's retry_after seconds; tests shrink it.
+var telegramRetryUnit = time.Second
+
+// postTelegram POSTs a JSON body to the Bot API, sleeping for the
+// parameters.retry_after hint and retrying when Telegram answers 429.
+func postTelegram(ctx context.Context, client *http.Client, u string, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= telegramMaxRetries {
+			return resp, nil
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		var parsed struct {
+			Parameters struct {
+				RetryAfter int64 `json:"retry_after"`
+			} `json:"parameters"`
+		}
+		_ = json.Unmarshal(b, &parsed)
+		retryAfter := parsed.Parameters.RetryAfter
+		if retryAfter <= 0 {
+			retryAfter = 1
+		}
+		if retryAfter > telegramMaxRetryAfter {
+			return nil, fmt.Errorf("telegram rate limited: retry_after=%ds exceeds max %ds", retryAfter, telegramMaxRetryAfter)
+		}
+		log.Printf("[Telegram] rate limited; retrying in %ds (attempt %d/%d)", retryAfter, attempt+1, telegramMaxRetries)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(retryAfter) * telegramRetryUnit):
+		}
+	}
 }
 
 func sendTelegramMessageFormatted(ctx context.Context, client *http.Client, cfg Config, formattedText string) (*int64, error) {
 	u := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", cfg.TelegramToken)
 	payload := telegramSendMessagePayload(cfg.TelegramChannelID, formattedText)
 	body, _ := json.Marshal(payload)
-	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(string(body)))
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := client.Do(req)
+	resp, err := postTelegram(ctx, client, u, body)
 	if err != nil {
 		return nil, err
 	}
@@ -591,16 +1500,17 @@ func sendTelegramMessageFormatted(ctx context.Context, client *http.Client, cfg
 }
 
 func sendTelegramPhoto(ctx context.Context, client *http.Client, cfg Config, imageURL, caption string) (*int64, error) {
-	formattedCaption := formatTelegramHTML(caption)
+	return sendTelegramPhotoFormatted(ctx, client, cfg, imageURL, formatTelegramHTML(caption))
+}
+
+func sendTelegramPhotoFormatted(ctx context.Context, client *http.Client, cfg Config, imageURL, formattedCaption string) (*int64, error) {
 	u := fmt.Sprintf("https://api.telegram.org/bot%s/sendPhoto", cfg.TelegramToken)
 	payload := telegramSendPhotoPayload(cfg.TelegramChannelID, imageURL)
-	if len([]rune(formattedCaption)) <= 1024 {
+	if len([]rune(formattedCaption)) <= telegramCaptionLimit {
 		payload["caption"] = formattedCaption
 	}
 	body, _ := json.Marshal(payload)
-	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(string(body)))
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := client.Do(req)
+	resp, err := postTelegram(ctx, client, u, body)
 	if err != nil {
 		return nil, err
 	}
@@ -639,6 +1549,10 @@ func sanitizeAIText(text string) string {
 
 var markdownBoldRE = regexp.MustCompile(`\*\*([^*]+)\*\*`)
 
+// escapedAnchorRE matches an <a href="http(s)://..."> anchor after
+// html.EscapeString, so links from the link formatter survive escaping.
+var escapedAnchorRE = regexp.MustCompile(`&lt;a href=&#34;(https?://[^\s<>]*?)&#34;&gt;(.*?)&lt;/a&gt;`)
+
 func telegramSendMessagePayload(chatID, formattedText string) map[string]any {
 	return map[string]any{"chat_id": chatID, "text": formattedText, "parse_mode": "HTML", "disable_web_page_preview": true}
 }
@@ -650,6 +1564,7 @@ func telegramSendPhotoPayload(chatID, imageURL string) map[string]any {
 func formatTelegramHTML(text string) string {
 	escaped := html.EscapeString(strings.TrimSpace(text))
 	escaped = markdownBoldRE.ReplaceAllString(escaped, "<b>$1</b>")
+	escaped = escapedAnchorRE.ReplaceAllString(escaped, `<a href="$1">$2</a>`)
 	return escaped
 }
 
@@ -662,25 +1577,35 @@ func firstCoinImageURL(coins []Coin) string {
 	return ""
 }
 
-func writeState(ctx context.Context, stateCollection *mongo.Collection, coinsCollection *mongo.Collection, topN int, convert string, coins []Coin) error {
-	if err := replaceStateCoins(ctx, coinsCollection, "top", coins); err != nil {
+func writeState(ctx context.Context, stateCollection *mongo.Collection, coinsCollection *mongo.Collection, topN int, convert string, coins []Coin, reported map[int64]bool) error {
+	if err := replaceStateCoins(ctx, coinsCollection, "top", coins, reported); err != nil {
 		return err
 	}
 
+	ids := reportedStateIDs(coins, reported)
+	_, err := stateCollection.ReplaceOne(ctx, bson.M{"_id": "top"}, stateDoc{ID: "top", UpdatedAt: time.Now().UTC(), TopN: int64(topN), Convert: convert, IDs: ids}, options.Replace().SetUpsert(true))
+	return err
+}
+
+// reportedStateIDs lists the reported coins in rank order for stateDoc.IDs.
+// Unreported buffer coins are left out so they are still announced if the
+// hysteresis margin is later turned off.
+func reportedStateIDs(coins []Coin, reported map[int64]bool) []int64 {
 	ids := make([]int64, 0, len(coins))
 	for _, c := range coins {
-		ids = append(ids, c.ID)
+		if reported[c.ID] {
+			ids = append(ids, c.ID)
+		}
 	}
-	_, err := stateCollection.ReplaceOne(ctx, bson.M{"_id": "top"}, stateDoc{ID: "top", UpdatedAt: time.Now().UTC(), TopN: int64(topN), Convert: convert, IDs: ids}, options.Replace().SetUpsert(true))
-	return err
+	return ids
 }
 
-func replaceStateCoins(ctx context.Context, coinsCollection *mongo.Collection, stateID string, coins []Coin) error {
+func replaceStateCoins(ctx context.Context, coinsCollection *mongo.Collection, stateID string, coins []Coin, reported map[int64]bool) error {
 	now := time.Now().UTC()
 	if _, err := coinsCollection.UpdateMany(ctx, bson.M{"state_id": stateID}, bson.M{"$set": bson.M{"is_active": false, "updated_at": now}}); err != nil {
 		return err
 	}
-	docs := buildStateCoinDocs(stateID, coins, now)
+	docs := buildStateCoinDocs(stateID, coins, reported, now)
 	if len(docs) == 0 {
 		return nil
 	}
@@ -692,12 +1617,17 @@ func replaceStateCoins(ctx context.Context, coinsCollection *mongo.Collection, s
 				"$set": bson.M{
 					"name":                d.Name,
 					"symbol":              d.Symbol,
+					"slug":                d.Slug,
 					"rank":                d.Rank,
 					"tick_timestamp":      d.TickTimestamp,
 					"market_cap":          d.MarketCap,
 					"market_cap_currency": d.MarketCapCurrency,
+					"market_caps":         d.MarketCaps,
+					"price":               d.Price,
+					"percent_change_24h":  d.PercentChange24h,
 					"image_url":           d.ImageURL,
 					"is_active":           true,
+					"reported":            d.Reported,
 					"updated_at":          now,
 				},
 				"$setOnInsert": bson.M{"created_at": now},
@@ -711,7 +1641,7 @@ func replaceStateCoins(ctx context.Context, coinsCollection *mongo.Collection, s
 	return nil
 }
 
-func buildStateCoinDocs(stateID string, coins []Coin, now time.Time) []stateCoinDoc {
+func buildStateCoinDocs(stateID string, coins []Coin, reported map[int64]bool, now time.Time) []stateCoinDoc {
 	out := make([]stateCoinDoc, 0, len(coins))
 	for _, coin := range coins {
 		out = append(out, stateCoinDoc{
@@ -719,19 +1649,24 @@ func buildStateCoinDocs(stateID string, coins []Coin, now time.Time) []stateCoin
 			ID:                coin.ID,
 			Name:              coin.Name,
 			Symbol:            coin.Symbol,
+			Slug:              coin.Slug,
 			Rank:              coin.Rank,
 			TickTimestamp:     now,
 			MarketCap:         coin.MarketCap,
 			MarketCapCurrency: coin.MarketCapCurrency,
+			MarketCaps:        coin.MarketCaps,
+			Price:             coin.Price,
+			PercentChange24h:  coin.PercentChange24h,
 			ImageURL:          coin.ImageURL,
 			IsActive:          true,
+			Reported:          reported[coin.ID],
 			Updated:           now,
 		})
 	}
 	return out
 }
 
-func loadStateCoins(ctx context.Context, coinsCollection *mongo.Collection, stateID string) ([]Coin, error) {
+func loadStateCoins(ctx context.Context, coinsCollection collectionReader, stateID string) ([]Coin, error) {
 	cur, err := coinsCollection.Find(ctx, bson.M{"state_id": stateID, "is_active": true}, options.Find().SetSort(bson.M{"rank": 1}))
 	if err != nil {
 		return nil, err
@@ -744,8 +1679,30 @@ func loadStateCoins(ctx context.Context, coinsCollection *mongo.Collection, stat
 		if err := cur.Decode(&doc); err != nil {
 			return nil, err
 		}
-		tickTS := doc.TickTimestamp.UTC()
-		out = append(out, Coin{ID: doc.ID, Name: doc.Name, Symbol: doc.Symbol, Rank: doc.Rank, TickTimestamp: &tickTS, MarketCap: doc.MarketCap, MarketCapCurrency: doc.MarketCapCurrency, ImageURL: doc.ImageURL})
+		out = append(out, stateCoinFromDoc(doc))
+	}
+	return out, cur.Err()
+}
+
+func stateCoinFromDoc(doc stateCoinDoc) Coin {
+	tickTS := doc.TickTimestamp.UTC()
+	return Coin{ID: doc.ID, Name: doc.Name, Symbol: doc.Symbol, Slug: doc.Slug, Rank: doc.Rank, TickTimestamp: &tickTS, MarketCap: doc.MarketCap, MarketCapCurrency: doc.MarketCapCurrency, MarketCaps: doc.MarketCaps, Price: doc.Price, PercentChange24h: doc.PercentChange24h, ImageURL: doc.ImageURL}
+}
+
+func loadReportedIDs(ctx context.Context, coinsCollection collectionReader, stateID string) (map[int64]bool, error) {
+	cur, err := coinsCollection.Find(ctx, bson.M{"state_id": stateID, "is_active": true, "reported": true})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := map[int64]bool{}
+	for cur.Next(ctx) {
+		var doc stateCoinDoc
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		out[doc.ID] = true
 	}
 	return out, cur.Err()
 }
@@ -780,7 +1737,7 @@ func ReplayLastTick(ctx context.Context, cfg Config, convert string) (string, *i
 	_, err = historyCollection.InsertOne(ctx, historyDoc{
 		CreatedAt:         time.Now().UTC(),
 		TopN:              last.TopN,
-		Convert:           convert,
+		Convert:           primaryCurrency(convert),
 		NewCoinIDs:        newIDs,
 		Text:              last.Text,
 		MentionedCoins:    last.MentionedCoins,
@@ -819,7 +1776,7 @@ func renderBlock(t string, root map[string]any, local map[string]any) string {
 			}
 			tag := strings.TrimSpace(t[i+6 : i+6+end])
 			blockStart := i + 6 + end + 1
-			endEach := strings.Index(t[blockStart:], "%END_EACH%")
+			_, endEach := findBlockEnd(t[blockStart:], "%EACH ", "%END_EACH%", "")
 			if endEach < 0 {
 				break
 			}
@@ -841,13 +1798,18 @@ func renderBlock(t string, root map[string]any, local map[string]any) string {
 			}
 			varName := strings.TrimSpace(t[i+4 : i+4+end])
 			blockStart := i + 4 + end + 1
-			endIf := strings.Index(t[blockStart:], "%END_IF%")
+			elseAt, endIf := findBlockEnd(t[blockStart:], "%IF ", "%END_IF%", "%ELSE%")
 			if endIf < 0 {
 				break
 			}
-			block := t[blockStart : blockStart+endIf]
+			block, elseBlock := t[blockStart:blockStart+endIf], ""
+			if elseAt >= 0 {
+				block, elseBlock = t[blockStart:blockStart+elseAt], t[blockStart+elseAt+len("%ELSE%"):blockStart+endIf]
+			}
 			if truthy(resolve(local, root, varName)) {
 				out.WriteString(renderBlock(block, root, local))
+			} else {
+				out.WriteString(renderBlock(elseBlock, root, local))
 			}
 			i = blockStart + endIf + len("%END_IF%")
 			continue
@@ -866,7 +1828,17 @@ func renderBlock(t string, root map[string]any, local map[string]any) string {
 			if len(parts) > 1 {
 				def = parts[1]
 			}
-			val := stringify(resolve(local, root, key))
+			formatter := ""
+			if idx := strings.Index(key, ":"); idx >= 0 {
+				formatter = strings.TrimSpace(key[idx+1:])
+				key = strings.TrimSpace(key[:idx])
+			}
+			var val string
+			if formatter == "link" {
+				val = formatCoinLink(local, root, key)
+			} else {
+				val = formatValue(resolve(local, root, key), formatter, valueCurrency(local, root, key))
+			}
 			if strings.TrimSpace(val) == "" {
 				val = def
 			}
@@ -880,6 +1852,33 @@ func renderBlock(t string, root map[string]any, local map[string]any) string {
 	return out.String()
 }
 
+// findBlockEnd returns the offsets of the closing tag matching an already
+// consumed opening tag, skipping nested blocks of the same kind, and of the
+// top-level separator tag (or -1 when absent or sep is empty).
+func findBlockEnd(t, open, close, sep string) (sepAt, end int) {
+	sepAt, depth := -1, 0
+	for i := 0; i < len(t); {
+		s := t[i:]
+		switch {
+		case strings.HasPrefix(s, open):
+			depth++
+			i += len(open)
+		case strings.HasPrefix(s, close):
+			if depth == 0 {
+				return sepAt, i
+			}
+			depth--
+			i += len(close)
+		case sep != "" && depth == 0 && sepAt < 0 && strings.HasPrefix(s, sep):
+			sepAt = i
+			i += len(sep)
+		default:
+			i++
+		}
+	}
+	return -1, -1
+}
+
 func resolve(local, root map[string]any, key string) any {
 	if key == "" {
 		return nil
@@ -889,7 +1888,64 @@ func resolve(local, root map[string]any, key string) any {
 			return v
 		}
 	}
-	return root[key]
+	if v, ok := root[key]; ok || !strings.Contains(key, ".") {
+		return v
+	}
+	segments := strings.Split(key, ".")
+	cur := resolve(local, root, segments[0])
+	for _, seg := range segments[1:] {
+		next, ok := lookupField(cur, seg)
+		if !ok {
+			return nil
+		}
+		cur = next
+	}
+	return cur
+}
+
+// lookupField reads a map key or struct field (by json tag or name) from v.
+func lookupField(v any, name string) (any, bool) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		mv := rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key()))
+		if !mv.IsValid() {
+			return nil, false
+		}
+		return derefValue(mv), true
+	case reflect.Struct:
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			f := rt.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			tag := strings.Split(f.Tag.Get("json"), ",")[0]
+			if tag == name || (tag == "" && f.Name == name) {
+				return derefValue(rv.Field(i)), true
+			}
+		}
+	}
+	return nil, false
+}
+
+func derefValue(rv reflect.Value) any {
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	return rv.Interface()
 }
 func truthy(v any) bool {
 	switch vv := v.(type) {
@@ -940,11 +1996,238 @@ func stringify(v any) string {
 			return "true"
 		}
 		return "false"
+	case time.Time:
+		return vv.UTC().Format(time.RFC3339)
 	default:
 		b, _ := json.Marshal(v)
 		return string(b)
 	}
 }
+
+// formatValue applies a token formatter (the part after ":" in %key:fmt%).
+// Values the formatter can't handle render as empty so the |default kicks in.
+// currency is only used by the compact and usd formatters.
+func formatValue(v any, formatter, currency string) string {
+	switch {
+	case formatter == "":
+		return stringify(v)
+	case formatter == "compact":
+		n, ok := toNumber(v)
+		if !ok {
+			return ""
+		}
+		return formatCompact(n, currency)
+	case formatter == "usd":
+		n, ok := toNumber(v)
+		if !ok {
+			return ""
+		}
+		return formatAmount(n, currency)
+	case strings.HasPrefix(formatter, "pad"):
+		width, err := strconv.Atoi(strings.TrimPrefix(formatter, "pad"))
+		n, ok := toNumber(v)
+		if err != nil || width <= 0 || !ok {
+			return ""
+		}
+		return fmt.Sprintf("%0*d", width, int64(math.Round(n)))
+	case strings.HasPrefix(formatter, "date(") && strings.HasSuffix(formatter, ")"):
+		layout := formatter[len("date(") : len(formatter)-1]
+		t, ok := toTime(v)
+		if !ok || !hasLayoutElements(layout) {
+			return stringify(v)
+		}
+		return t.Format(layout)
+	case formatter == "relative":
+		t, ok := toTime(v)
+		if !ok {
+			return stringify(v)
+		}
+		return formatRelative(templateNow().Sub(t))
+	default:
+		return stringify(v)
+	}
+}
+
+// valueCurrency returns the currency of the amount at key: CUR for a
+// market_cap_CUR key, else the sibling market_cap_currency (%coin.price% reads
+// coin.market_cap_currency), else USD.
+func valueCurrency(local, root map[string]any, key string) string {
+	prefix, field := "", key
+	if idx := strings.LastIndex(key, "."); idx >= 0 {
+		prefix, field = key[:idx+1], key[idx+1:]
+	}
+	if cur, ok := strings.CutPrefix(field, "market_cap_"); ok && cur != "currency" && cur != "" {
+		return strings.ToUpper(cur)
+	}
+	if cur := strings.TrimSpace(stringify(resolve(local, root, prefix+"market_cap_currency"))); cur != "" {
+		return strings.ToUpper(cur)
+	}
+	return "USD"
+}
+
+// formatCoinLink renders key as an HTML anchor to the CoinMarketCap page of
+// the coin it belongs to, using the sibling slug field (%coin.name:link% reads
+// coin.slug). Without a slug the plain value is returned.
+func formatCoinLink(local, root map[string]any, key string) string {
+	text := stringify(resolve(local, root, key))
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+	slugKey := "slug"
+	if idx := strings.LastIndex(key, "."); idx >= 0 {
+		slugKey = key[:idx+1] + slugKey
+	}
+	slug := strings.TrimSpace(stringify(resolve(local, root, slugKey)))
+	if slug == "" {
+		return text
+	}
+	return fmt.Sprintf(`<a href="%s">%s</a>`, coinMarketCapURL(slug), text)
+}
+
+func coinMarketCapURL(slug string) string {
+	return "https://coinmarketcap.com/currencies/" + url.PathEscape(slug) + "/"
+}
+
+// templateNow is the reference time for the relative formatter; tests pin it.
+var templateNow = time.Now
+
+// layoutProbes differ in every layout element (year, month, day, weekday,
+// hour, AM/PM, minute, second, fraction and zone).
+var layoutProbes = [2]time.Time{
+	time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC),
+	time.Date(2012, 11, 11, 13, 14, 15, 123456789, time.FixedZone("X", 5*3600+30*60)),
+}
+
+// hasLayoutElements reports whether layout contains any Go time layout
+// element; a layout without one (eg a typo) formats every time identically.
+func hasLayoutElements(layout string) bool {
+	return layout != "" && layoutProbes[0].Format(layout) != layoutProbes[1].Format(layout)
+}
+
+// toTime accepts time values and RFC3339 strings such as timestamp_utc.
+func toTime(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, false
+		}
+		return *t, true
+	case string:
+		parsed, err := time.Parse(time.RFC3339, strings.TrimSpace(t))
+		return parsed, err == nil
+	}
+	return time.Time{}, false
+}
+
+// formatRelative renders d as "just now", "5m ago", "2h ago", "3d ago", or
+// "in 2h" for future times.
+func formatRelative(d time.Duration) string {
+	future := d < 0
+	if future {
+		d = -d
+	}
+	var n string
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		n = fmt.Sprintf("%dm", int64(d/time.Minute))
+	case d < 24*time.Hour:
+		n = fmt.Sprintf("%dh", int64(d/time.Hour))
+	default:
+		n = fmt.Sprintf("%dd", int64(d/(24*time.Hour)))
+	}
+	if future {
+		return "in " + n
+	}
+	return n + " ago"
+}
+
+var compactSuffixes = []struct {
+	div    float64
+	suffix string
+}{{1, ""}, {1e3, "K"}, {1e6, "M"}, {1e9, "B"}, {1e12, "T"}}
+
+// currencySymbols are prefixed to amounts; other currencies get a trailing
+// code instead, eg "1.23B CHF".
+var currencySymbols = map[string]string{"USD": "$", "EUR": "€", "GBP": "£"}
+
+func withCurrency(sign, amount, currency string) string {
+	if symbol, ok := currencySymbols[currency]; ok {
+		return sign + symbol + amount
+	}
+	return sign + amount + " " + currency
+}
+
+func formatCompact(n float64, currency string) string {
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	tier := 0
+	for tier+1 < len(compactSuffixes) && n >= compactSuffixes[tier+1].div {
+		tier++
+	}
+	scaled := math.Round(n/compactSuffixes[tier].div*100) / 100
+	// 999_999 rounds to 1000K; promote it to 1M instead.
+	if scaled >= 1000 && tier+1 < len(compactSuffixes) {
+		tier++
+		scaled = math.Round(n/compactSuffixes[tier].div*100) / 100
+	}
+	if scaled == 0 {
+		sign = ""
+	}
+	return withCurrency(sign, trimDecimals(scaled)+compactSuffixes[tier].suffix, currency)
+}
+
+func formatAmount(n float64, currency string) string {
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	digits := strconv.FormatFloat(math.Round(n), 'f', 0, 64)
+	if digits == "0" {
+		sign = ""
+	}
+	var b strings.Builder
+	for i, r := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	return withCurrency(sign, b.String(), currency)
+}
+
+func trimDecimals(n float64) string {
+	s := strconv.FormatFloat(n, 'f', 2, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func toNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case *float64:
+		if n == nil {
+			return 0, false
+		}
+		return *n, true
+	case float32:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	default:
+		return asFloat(v)
+	}
+}
+
 func asString(v any) string { s, _ := v.(string); return s }
 func asStringDef(v any, def string) string {
 	if s := asString(v); s != "" {
@@ -0,0 +1,265 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type flakyCMCRoundTripper struct {
+	failures     int
+	listingCalls int
+	listingBody  string
+}
+
+func (f *flakyCMCRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	status, body := 200, `{"data":{}}`
+	if strings.Contains(req.URL.Path, "listings") {
+		f.listingCalls++
+		status, body = 200, f.listingBody
+		if f.listingCalls <= f.failures {
+			status, body = 429, `{"status":{"error_code":1008}}`
+		}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       io.NopCloser(bytes.NewBufferString(body)),
+		Header:     make(http.Header),
+	}, nil
+}
+
+const twoCoinListing = `{"data":[
+	{"id":1,"name":"Bitcoin","symbol":"BTC","cmc_rank":1,"quote":{"USD":{"market_cap":1000}}},
+	{"id":1027,"name":"Ethereum","symbol":"ETH","cmc_rank":2,"quote":{"USD":{"market_cap":500}}}
+]}`
+
+func TestFetchCMCTopNRetriesOnRateLimit(t *testing.T) {
+	rt := &flakyCMCRoundTripper{failures: 2, listingBody: twoCoinListing}
+	client := &http.Client{Transport: rt}
+	cfg := Config{TopN: 2, CMCMaxRetries: 3, CMCRetryBase: time.Millisecond}
+
+	coins, err := fetchCMCTopN(context.Background(), client, cfg, RunOptions{Convert: "USD"}, nil)
+	if err != nil {
+		t.Fatalf("fetchCMCTopN error: %v", err)
+	}
+	if rt.listingCalls != 3 {
+		t.Fatalf("expected 3 listing attempts, got %d", rt.listingCalls)
+	}
+	if len(coins) != 2 || coins[0].Symbol != "BTC" || coins[1].Symbol != "ETH" {
+		t.Fatalf("unexpected coins: %+v", coins)
+	}
+}
+
+func TestFetchCMCTopNGivesUpAfterMaxRetries(t *testing.T) {
+	rt := &flakyCMCRoundTripper{failures: 5, listingBody: twoCoinListing}
+	client := &http.Client{Transport: rt}
+	cfg := Config{TopN: 2, CMCMaxRetries: 1, CMCRetryBase: time.Millisecond}
+
+	if _, err := fetchCMCTopN(context.Background(), client, cfg, RunOptions{Convert: "USD"}, nil); err == nil {
+		t.Fatalf("expected error after exhausting retries")
+	}
+	if rt.listingCalls != 2 {
+		t.Fatalf("expected 2 listing attempts, got %d", rt.listingCalls)
+	}
+}
+
+func TestDoWithRetryRespectsContextCancellation(t *testing.T) {
+	rt := &flakyCMCRoundTripper{failures: 5, listingBody: twoCoinListing}
+	client := &http.Client{Transport: rt}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://example.com/listings", nil)
+
+	if _, err := doWithRetry(ctx, client, req, 5, time.Hour); err == nil {
+		t.Fatalf("expected context error")
+	}
+	if rt.listingCalls != 1 {
+		t.Fatalf("expected a single attempt before cancellation, got %d", rt.listingCalls)
+	}
+}
+
+func TestRetryAfterDelay(t *testing.T) {
+	if got := retryAfterDelay("2"); got != 2*time.Second {
+		t.Fatalf("retryAfterDelay(2) = %s", got)
+	}
+	if got := retryAfterDelay(""); got != 0 {
+		t.Fatalf("retryAfterDelay(empty) = %s", got)
+	}
+	if got := retryAfterDelay("soon"); got != 0 {
+		t.Fatalf("retryAfterDelay(soon) = %s", got)
+	}
+	if got := retryAfterDelay("86400"); got != maxRetryDelay {
+		t.Fatalf("retryAfterDelay(86400) = %s, want cap %s", got, maxRetryDelay)
+	}
+	if got := retryAfterDelay(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); got != maxRetryDelay {
+		t.Fatalf("retryAfterDelay(date in 1h) = %s, want cap %s", got, maxRetryDelay)
+	}
+}
+
+func TestFetchCMCTopNParsesMultipleQuotes(t *testing.T) {
+	var listingURL string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if !strings.Contains(req.URL.Path, "listings") {
+			return jsonResponse(200, `{"data":{}}`), nil
+		}
+		listingURL = req.URL.String()
+		return jsonResponse(200, `{"data":[
+			{"id":1,"name":"Bitcoin","symbol":"BTC","cmc_rank":1,"quote":{"USD":{"market_cap":1000},"EUR":{"market_cap":920}}},
+			{"id":2,"name":"NoEuro","symbol":"NOE","cmc_rank":2,"quote":{"USD":{"market_cap":10}}}
+		]}`), nil
+	})}
+	cfg := Config{TopN: 2}
+
+	coins, err := fetchCMCTopN(context.Background(), client, cfg, RunOptions{Convert: "usd, EUR"}, nil)
+	if err != nil {
+		t.Fatalf("fetchCMCTopN error: %v", err)
+	}
+	if !strings.Contains(listingURL, "convert=USD%2CEUR") {
+		t.Fatalf("expected both currencies in convert param, got %s", listingURL)
+	}
+	btc := coins[0]
+	if btc.MarketCapCurrency != "USD" || btc.MarketCap == nil || *btc.MarketCap != 1000 {
+		t.Fatalf("primary market cap should stay USD: %+v", btc)
+	}
+	if btc.MarketCaps["USD"] != 1000 || btc.MarketCaps["EUR"] != 920 {
+		t.Fatalf("unexpected per-currency caps: %v", btc.MarketCaps)
+	}
+	if _, ok := coins[1].MarketCaps["EUR"]; ok || coins[1].MarketCaps["USD"] != 10 {
+		t.Fatalf("missing quotes should be omitted: %v", coins[1].MarketCaps)
+	}
+
+	renderCtx := buildRenderContext(cfg, RunOptions{Convert: "USD,EUR"}, coins, []Coin{}, []MovedCoin{}, []RecentPost{})
+	got := RenderTemplate("%convert% %EACH new_coins%%symbol% %market_cap_USD% %market_cap_EUR|n/a%;%END_EACH%", renderCtx)
+	if want := "USD BTC 1000 920;NOE 10 n/a;"; got != want {
+		t.Fatalf("unexpected output: got %q want %q", got, want)
+	}
+}
+
+func TestConvertCurrencies(t *testing.T) {
+	if got := convertCurrencies(" usd ,eur,,"); len(got) != 2 || got[0] != "USD" || got[1] != "EUR" {
+		t.Fatalf("unexpected currencies: %v", got)
+	}
+	if got := primaryCurrency(""); got != "USD" {
+		t.Fatalf("primaryCurrency(empty) = %q", got)
+	}
+}
+
+func TestFetchCMCTopNParsesPriceAndChange(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if !strings.Contains(req.URL.Path, "listings") {
+			return jsonResponse(200, `{"data":{}}`), nil
+		}
+		return jsonResponse(200, `{"data":[
+			{"id":1,"name":"Bitcoin","symbol":"BTC","cmc_rank":1,"quote":{"USD":{"market_cap":1000,"price":64000.5,"percent_change_24h":-2.25}}},
+			{"id":2,"name":"Bare","symbol":"BARE","cmc_rank":2,"quote":{"USD":{"market_cap":10}}}
+		]}`), nil
+	})}
+
+	coins, err := fetchCMCTopN(context.Background(), client, Config{TopN: 2}, RunOptions{Convert: "USD"}, nil)
+	if err != nil {
+		t.Fatalf("fetchCMCTopN error: %v", err)
+	}
+	btc, bare := coins[0], coins[1]
+	if btc.Price == nil || *btc.Price != 64000.5 || btc.PercentChange24h == nil || *btc.PercentChange24h != -2.25 {
+		t.Fatalf("unexpected price/change: %+v", btc)
+	}
+	if bare.Price != nil || bare.PercentChange24h != nil {
+		t.Fatalf("missing quote fields should stay nil: %+v", bare)
+	}
+
+	renderCtx := buildRenderContext(Config{TopN: 2}, RunOptions{Convert: "USD"}, coins, []Coin{}, []MovedCoin{}, []RecentPost{})
+	got := RenderTemplate("%EACH new_coins%%symbol% %price|n/a% %percent_change_24h|n/a%%IF price% priced%END_IF%;%END_EACH%", renderCtx)
+	if want := "BTC 64000.5 -2.25 priced;BARE n/a n/a;"; got != want {
+		t.Fatalf("unexpected output: got %q want %q", got, want)
+	}
+}
+
+func TestBuildStateCoinDocsKeepsPriceAndChange(t *testing.T) {
+	price, change := 1.5, 3.0
+	docs := buildStateCoinDocs("top", []Coin{{ID: 1, Price: &price, PercentChange24h: &change}, {ID: 2}}, nil, time.Now())
+	if docs[0].Price != &price || docs[0].PercentChange24h != &change {
+		t.Fatalf("price/change not persisted: %+v", docs[0])
+	}
+	if docs[1].Price != nil || docs[1].PercentChange24h != nil {
+		t.Fatalf("missing price/change should stay nil: %+v", docs[1])
+	}
+}
+
+func TestFetchCMCTopNParsesSlug(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if !strings.Contains(req.URL.Path, "listings") {
+			return jsonResponse(200, `{"data":{}}`), nil
+		}
+		return jsonResponse(200, `{"data":[
+			{"id":1,"name":"Bitcoin","symbol":"BTC","slug":"bitcoin","cmc_rank":1},
+			{"id":2,"name":"Bare","symbol":"BARE","cmc_rank":2}
+		]}`), nil
+	})}
+
+	coins, err := fetchCMCTopN(context.Background(), client, Config{TopN: 2}, RunOptions{Convert: "USD"}, nil)
+	if err != nil {
+		t.Fatalf("fetchCMCTopN error: %v", err)
+	}
+	if coins[0].Slug != "bitcoin" || coins[1].Slug != "" {
+		t.Fatalf("unexpected slugs: %q %q", coins[0].Slug, coins[1].Slug)
+	}
+	docs := buildStateCoinDocs("top", coins, nil, time.Now())
+	if docs[0].Slug != "bitcoin" {
+		t.Fatalf("slug not persisted: %+v", docs[0])
+	}
+}
+
+func TestFetchCMCTopNRejectsErrorStatus(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return jsonResponse(200, `{"status":{"error_code":1002,"error_message":"API key missing."},"data":[]}`), nil
+	})}
+
+	_, err := fetchCMCTopN(context.Background(), client, Config{TopN: 2}, RunOptions{Convert: "USD"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "error_code=1002") || !strings.Contains(err.Error(), "API key missing.") {
+		t.Fatalf("expected descriptive status error, got %v", err)
+	}
+}
+
+func TestFetchCMCTopNRejectsMalformedPayload(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return jsonResponse(200, `{"status":{"error_code":0},"data":{"unexpected":true}}`), nil
+	})}
+
+	if _, err := fetchCMCTopN(context.Background(), client, Config{TopN: 2}, RunOptions{Convert: "USD"}, nil); err == nil {
+		t.Fatalf("expected an error for a listings payload without a data array")
+	}
+}
+
+func TestDetectTickAbortsOnShortListing(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if !strings.Contains(req.URL.Path, "listings") {
+			return jsonResponse(200, `{"data":{}}`), nil
+		}
+		return jsonResponse(200, twoCoinListing), nil
+	})}
+
+	// Nil collections: the tick must abort before reading or writing state.
+	var state, coins, history collectionReader
+	logos := &fakeLogoStore{}
+	_, err := detectTick(context.Background(), client, Config{TopN: 10, LogoCacheTTL: time.Hour}, RunOptions{Convert: "USD"}, state, coins, history, logos)
+	if err == nil || !strings.Contains(err.Error(), "cmc returned 2 coins for top-10") {
+		t.Fatalf("expected short listing to abort the tick, got %v", err)
+	}
+}
+
+func TestCheckFetchedCount(t *testing.T) {
+	if err := checkFetchedCount(50, 100); err != nil {
+		t.Fatalf("half of top-n should pass: %v", err)
+	}
+	if err := checkFetchedCount(49, 100); err == nil {
+		t.Fatalf("less than half of top-n should fail")
+	}
+	if err := checkFetchedCount(1, 1); err != nil {
+		t.Fatalf("full list should pass: %v", err)
+	}
+}
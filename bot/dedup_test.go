@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type fakeHistory struct {
+	doc    *historyDoc
+	err    error
+	calls  int
+	filter bson.M
+}
+
+func (f *fakeHistory) FindOne(ctx context.Context, filter any, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	f.calls++
+	f.filter, _ = filter.(bson.M)
+	if f.err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, f.err, nil)
+	}
+	if f.doc == nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(f.doc, nil, nil)
+}
+
+func TestDedupKeyIsOrderIndependent(t *testing.T) {
+	updated := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	a := dedupKey([]int64{3, 1, 2}, updated)
+	b := dedupKey([]int64{1, 2, 3}, updated)
+	if a != b {
+		t.Fatalf("keys differ for the same set: %s != %s", a, b)
+	}
+	if a == dedupKey([]int64{1, 2}, updated) {
+		t.Fatalf("different id sets must produce different keys")
+	}
+	if a == dedupKey([]int64{1, 2, 3}, updated.Add(time.Minute)) {
+		t.Fatalf("different state timestamps must produce different keys")
+	}
+}
+
+func TestDedupKeyDoesNotMutateInput(t *testing.T) {
+	ids := []int64{3, 1, 2}
+	_ = dedupKey(ids, time.Time{})
+	if ids[0] != 3 || ids[1] != 1 || ids[2] != 2 {
+		t.Fatalf("input slice was reordered: %v", ids)
+	}
+}
+
+func TestFindRecentDuplicateSkipsWhenMatchExists(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	key := dedupKey([]int64{1}, now)
+	history := &fakeHistory{doc: &historyDoc{CreatedAt: now.Add(-time.Minute), DedupKey: key}}
+
+	dup, err := findRecentDuplicate(context.Background(), history, key, 10*time.Minute, now)
+	if err != nil {
+		t.Fatalf("findRecentDuplicate error: %v", err)
+	}
+	if !dup {
+		t.Fatalf("expected duplicate to be detected")
+	}
+	if history.filter["dedup_key"] != key {
+		t.Fatalf("unexpected filter: %v", history.filter)
+	}
+	since, _ := history.filter["created_at"].(bson.M)
+	if since["$gte"] != now.Add(-10*time.Minute) {
+		t.Fatalf("unexpected created_at window: %v", since)
+	}
+}
+
+func TestFindRecentDuplicateNoMatchOrDisabled(t *testing.T) {
+	now := time.Now().UTC()
+	history := &fakeHistory{}
+	if dup, err := findRecentDuplicate(context.Background(), history, "k", time.Minute, now); err != nil || dup {
+		t.Fatalf("expected no duplicate, got dup=%t err=%v", dup, err)
+	}
+
+	disabled := &fakeHistory{doc: &historyDoc{DedupKey: "k"}}
+	if dup, _ := findRecentDuplicate(context.Background(), disabled, "k", 0, now); dup || disabled.calls != 0 {
+		t.Fatalf("zero window must disable the check")
+	}
+
+	failing := &fakeHistory{err: errors.New("boom")}
+	if _, err := findRecentDuplicate(context.Background(), failing, "k", time.Minute, now); err == nil {
+		t.Fatalf("expected lookup error to be returned")
+	}
+}
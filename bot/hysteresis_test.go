@@ -0,0 +1,179 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+// rankedCoins builds a fetched list where each id's rank is its position.
+func rankedCoins(ids ...int64) []Coin {
+	coins := make([]Coin, 0, len(ids))
+	for i, id := range ids {
+		coins = append(coins, Coin{ID: id, Rank: int64(i + 1)})
+	}
+	return coins
+}
+
+// simState mirrors what RunOnce keeps in MongoDB: the state coin docs with
+// their reported flags plus stateDoc.IDs. Like RunOnce it writes a baseline
+// on the first tick and afterwards persists only when a post would be sent.
+type simState struct {
+	topN, margin, threshold int
+	docs                    []stateCoinDoc
+	ids                     []int64
+	written                 bool
+}
+
+func (s *simState) persist(fetched []Coin, reported map[int64]bool) {
+	s.docs = buildStateCoinDocs("top", fetched, reported, time.Now())
+	s.ids = reportedStateIDs(fetched, reported)
+	s.written = true
+}
+
+func (s *simState) tick(fetched []Coin) ([]Coin, []MovedCoin) {
+	if !s.written {
+		current := fetched
+		if len(current) > s.topN {
+			current = current[:s.topN]
+		}
+		s.persist(fetched, coinIDSet(current))
+		return nil, nil
+	}
+	prevCoins := make([]Coin, 0, len(s.docs))
+	flagged := map[int64]bool{}
+	for _, d := range s.docs {
+		prevCoins = append(prevCoins, stateCoinFromDoc(d))
+		if d.Reported {
+			flagged[d.ID] = true
+		}
+	}
+	newCoins, moved, reported := diffCoins(fetched, prevCoins, previousReported(s.ids, flagged), s.topN, s.margin, s.threshold)
+	if len(newCoins) > 0 || len(moved) > 0 {
+		s.persist(fetched, reported)
+	}
+	return newCoins, moved
+}
+
+func countID(coins []Coin, id int64) int {
+	n := 0
+	for _, c := range coins {
+		if c.ID == id {
+			n++
+		}
+	}
+	return n
+}
+
+func TestHysteresisWithoutMarginReannouncesFlappingCoin(t *testing.T) {
+	st := &simState{topN: 5}
+	st.tick(rankedCoins(1, 2, 3, 4, 5))
+	announced := 0
+	// Coin 9 flaps around rank 5; each exit coincides with another entry,
+	// so a post is sent and the state without coin 9 is persisted.
+	for _, fetched := range [][]Coin{
+		rankedCoins(1, 2, 3, 4, 9),
+		rankedCoins(1, 2, 3, 4, 6),
+		rankedCoins(1, 2, 3, 4, 9),
+		rankedCoins(1, 2, 3, 4, 7),
+		rankedCoins(1, 2, 3, 4, 9),
+	} {
+		newCoins, _ := st.tick(fetched)
+		announced += countID(newCoins, 9)
+	}
+	if announced != 3 {
+		t.Fatalf("margin 0 should announce on every entry, got %d", announced)
+	}
+}
+
+func TestHysteresisMarginSuppressesBoundaryFlapping(t *testing.T) {
+	st := &simState{topN: 5, margin: 1}
+	st.tick(rankedCoins(1, 2, 3, 4, 5, 6))
+	for i, fetched := range [][]Coin{
+		rankedCoins(7, 1, 2, 3, 9, 4), // 7 is posted; 9 sits on the boundary
+		rankedCoins(7, 1, 2, 3, 4, 9),
+		rankedCoins(8, 7, 1, 2, 9, 3), // 8 is posted; 9 is back on the boundary
+		rankedCoins(8, 7, 1, 2, 3, 9),
+	} {
+		newCoins, _ := st.tick(fetched)
+		if countID(newCoins, 9) != 0 {
+			t.Fatalf("tick %d: coin 9 should not be announced inside the margin", i)
+		}
+	}
+	newCoins, _ := st.tick(rankedCoins(8, 7, 9, 1, 2, 3))
+	if countID(newCoins, 9) != 1 {
+		t.Fatalf("coin 9 should be announced once it clears the margin, got %+v", newCoins)
+	}
+}
+
+func TestHysteresisKeepsStateWhenNothingIsPosted(t *testing.T) {
+	st := &simState{topN: 5, margin: 1}
+	st.tick(rankedCoins(1, 2, 3, 4, 5, 6))
+	if newCoins, _ := st.tick(rankedCoins(9, 1, 2, 3, 4, 5)); countID(newCoins, 9) != 1 {
+		t.Fatalf("coin 9 should be announced, got %+v", newCoins)
+	}
+	// Coin 9 falls out entirely, but nothing else changes: no post, so the
+	// stored state still has it reported and its return is not re-announced.
+	if newCoins, _ := st.tick(rankedCoins(1, 2, 3, 4, 5, 6)); len(newCoins) != 0 {
+		t.Fatalf("nothing should be new, got %+v", newCoins)
+	}
+	if newCoins, _ := st.tick(rankedCoins(9, 1, 2, 3, 4, 5)); countID(newCoins, 9) != 0 {
+		t.Fatalf("state was not rewritten, so coin 9 is still reported: %+v", newCoins)
+	}
+
+	// Once a post persists a state without coin 9, a later entry is new again.
+	st.tick(rankedCoins(7, 1, 2, 3, 4, 5))
+	if newCoins, _ := st.tick(rankedCoins(9, 7, 1, 2, 3, 4)); countID(newCoins, 9) != 1 {
+		t.Fatalf("re-entry after a persisted exit should be announced, got %+v", newCoins)
+	}
+}
+
+func TestDiffCoinsDoesNotReportNewBufferCoinAsMoved(t *testing.T) {
+	st := &simState{topN: 4, margin: 1, threshold: 2}
+	st.tick(rankedCoins(1, 2, 3, 4, 9))
+	newCoins, moved := st.tick(rankedCoins(9, 1, 2, 3, 4))
+	if len(newCoins) != 1 || newCoins[0].ID != 9 {
+		t.Fatalf("expected coin 9 to be new, got %+v", newCoins)
+	}
+	for _, m := range moved {
+		if m.ID == 9 {
+			t.Fatalf("coin 9 is new and must not also be a rank jump: %+v", moved)
+		}
+	}
+}
+
+func TestDiffCoinsReportsMovesOfAnnouncedCoins(t *testing.T) {
+	st := &simState{topN: 4, margin: 1, threshold: 2}
+	st.tick(rankedCoins(1, 2, 3, 4, 9))
+	newCoins, moved := st.tick(rankedCoins(4, 1, 2, 3, 9))
+	if len(newCoins) != 0 || len(moved) != 1 || moved[0].ID != 4 || moved[0].PrevRank != 4 || moved[0].RankDelta != 3 {
+		t.Fatalf("expected only coin 4 to move 4->1, got new=%+v moved=%+v", newCoins, moved)
+	}
+}
+
+func TestStateIDsExcludeBufferCoinsWhenMarginIsDisabled(t *testing.T) {
+	st := &simState{topN: 5, margin: 1}
+	st.tick(rankedCoins(1, 2, 3, 4, 5, 9))
+	st.tick(rankedCoins(7, 1, 2, 3, 4, 9)) // 7 is posted; 9 stays an unreported buffer coin
+	for _, id := range st.ids {
+		if id == 9 {
+			t.Fatalf("unreported buffer coin stored in state ids: %v", st.ids)
+		}
+	}
+
+	// Turning the margin off must still announce coin 9 when it enters.
+	st.margin = 0
+	if newCoins, _ := st.tick(rankedCoins(7, 1, 9, 2, 3)); countID(newCoins, 9) != 1 {
+		t.Fatalf("coin 9 should be announced after the margin is disabled, got %+v", newCoins)
+	}
+}
+
+func TestPreviousReportedFallsBackToLegacyIDs(t *testing.T) {
+	legacy := previousReported([]int64{1, 2}, map[int64]bool{})
+	if !legacy[1] || !legacy[2] || len(legacy) != 2 {
+		t.Fatalf("state without reported flags should use ids, got %v", legacy)
+	}
+	flagged := previousReported([]int64{1, 2, 9}, map[int64]bool{1: true})
+	if !flagged[1] || flagged[9] || len(flagged) != 1 {
+		t.Fatalf("reported flags should win over ids, got %v", flagged)
+	}
+}
@@ -0,0 +1,138 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type fakeLogoStore struct {
+	docs   []logoDoc
+	writes []logoDoc
+}
+
+func (f *fakeLogoStore) Find(ctx context.Context, filter any, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	m, _ := filter.(bson.M)
+	freshSince, _ := m["fetched_at"].(bson.M)["$gte"].(time.Time)
+	ids := map[int64]bool{}
+	for _, id := range m["_id"].(bson.M)["$in"].([]int64) {
+		ids[id] = true
+	}
+	out := []any{}
+	for _, d := range f.docs {
+		if ids[d.ID] && !d.FetchedAt.Before(freshSince) {
+			out = append(out, d)
+		}
+	}
+	return mongo.NewCursorFromDocuments(out, nil, nil)
+}
+
+func (f *fakeLogoStore) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	for _, model := range models {
+		if replace, ok := model.(*mongo.ReplaceOneModel); ok {
+			f.writes = append(f.writes, replace.Replacement.(logoDoc))
+		}
+	}
+	return &mongo.BulkWriteResult{}, nil
+}
+
+func TestFetchCMCLogosRequestsOnlyUncachedIDs(t *testing.T) {
+	now := time.Now().UTC()
+	cache := &fakeLogoStore{docs: []logoDoc{
+		{ID: 1, Logo: "https://logo/1-cached.png", FetchedAt: now.Add(-time.Hour)},
+		{ID: 2, Logo: "https://logo/2-stale.png", FetchedAt: now.Add(-48 * time.Hour)},
+	}}
+	var requested []string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.Query().Get("id"))
+		return jsonResponse(200, `{"data":{"2":{"logo":"https://logo/2.png"},"3":{"logo":"https://logo/3.png"}}}`), nil
+	})}
+	cfg := Config{LogoCacheTTL: 24 * time.Hour}
+
+	logos, err := fetchCMCLogos(context.Background(), client, cfg, []Coin{{ID: 1}, {ID: 2}, {ID: 3}}, cache)
+	if err != nil {
+		t.Fatalf("fetchCMCLogos error: %v", err)
+	}
+	if len(requested) != 1 || requested[0] != "2,3" {
+		t.Fatalf("expected a single info request for ids 2,3, got %v", requested)
+	}
+	if logos[1] != "https://logo/1-cached.png" || logos[2] != "https://logo/2.png" || logos[3] != "https://logo/3.png" {
+		t.Fatalf("unexpected merged logos: %v", logos)
+	}
+	if len(cache.writes) != 2 {
+		t.Fatalf("expected fetched logos to be cached, got %+v", cache.writes)
+	}
+	for _, w := range cache.writes {
+		if w.ID == 1 || w.FetchedAt.Before(now) {
+			t.Fatalf("unexpected cache write: %+v", w)
+		}
+	}
+}
+
+func TestFetchCMCLogosSkipsRequestOnFullCacheHit(t *testing.T) {
+	cache := &fakeLogoStore{docs: []logoDoc{{ID: 1, Logo: "https://logo/1.png", FetchedAt: time.Now().UTC()}}}
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Fatalf("unexpected request to %s", req.URL)
+		return nil, nil
+	})}
+
+	logos, err := fetchCMCLogos(context.Background(), client, Config{LogoCacheTTL: time.Hour}, []Coin{{ID: 1}}, cache)
+	if err != nil {
+		t.Fatalf("fetchCMCLogos error: %v", err)
+	}
+	if logos[1] != "https://logo/1.png" || len(cache.writes) != 0 {
+		t.Fatalf("unexpected result: logos=%v writes=%+v", logos, cache.writes)
+	}
+}
+
+func TestFetchCMCLogosWithoutCacheRequestsAll(t *testing.T) {
+	var requested string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = req.URL.Query().Get("id")
+		return jsonResponse(200, `{"data":{"1":{"logo":"https://logo/1.png"}}}`), nil
+	})}
+
+	logos, err := fetchCMCLogos(context.Background(), client, Config{LogoCacheTTL: time.Hour}, []Coin{{ID: 1}, {ID: 2}}, nil)
+	if err != nil {
+		t.Fatalf("fetchCMCLogos error: %v", err)
+	}
+	if requested != "1,2" || !strings.HasSuffix(logos[1], "1.png") {
+		t.Fatalf("unexpected request %q or logos %v", requested, logos)
+	}
+}
+
+func TestFetchCMCLogosCachesMissingLogos(t *testing.T) {
+	cache := &fakeLogoStore{}
+	requests := 0
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return jsonResponse(200, `{"data":{"1":{"logo":"https://logo/1.png"},"2":{"logo":""}}}`), nil
+	})}
+	cfg := Config{LogoCacheTTL: time.Hour}
+	coins := []Coin{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	logos, err := fetchCMCLogos(context.Background(), client, cfg, coins, cache)
+	if err != nil {
+		t.Fatalf("fetchCMCLogos error: %v", err)
+	}
+	if logos[1] != "https://logo/1.png" || logos[2] != "" || logos[3] != "" {
+		t.Fatalf("unexpected logos: %v", logos)
+	}
+	if len(cache.writes) != 3 {
+		t.Fatalf("expected an entry per requested id, including empty ones, got %+v", cache.writes)
+	}
+
+	cache.docs, cache.writes = cache.writes, nil
+	if _, err := fetchCMCLogos(context.Background(), client, cfg, coins, cache); err != nil {
+		t.Fatalf("fetchCMCLogos error: %v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("ids without a logo should be served from cache, got %d requests", requests)
+	}
+}
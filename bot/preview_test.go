@@ -0,0 +1,133 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fakeCollection serves FindOne/Find from memory. Find applies the scalar
+// equality conditions of a bson.M filter and ignores everything else.
+type fakeCollection struct {
+	one  any
+	docs []any
+}
+
+func (f *fakeCollection) FindOne(ctx context.Context, filter any, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	if f.one == nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(f.one, nil, nil)
+}
+
+func (f *fakeCollection) Find(ctx context.Context, filter any, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	conds, _ := filter.(bson.M)
+	out := []any{}
+	for _, d := range f.docs {
+		raw, err := bson.Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		var fields bson.M
+		if err := bson.Unmarshal(raw, &fields); err != nil {
+			return nil, err
+		}
+		match := true
+		for k, want := range conds {
+			switch want.(type) {
+			case string, bool:
+				match = match && fields[k] == want
+			}
+		}
+		if match {
+			out = append(out, d)
+		}
+	}
+	return mongo.NewCursorFromDocuments(out, nil, nil)
+}
+
+// hostRewriter sends requests for the real API hosts to local test servers.
+type hostRewriter map[string]*httptest.Server
+
+func (h hostRewriter) RoundTrip(req *http.Request) (*http.Response, error) {
+	srv, ok := h[req.URL.Host]
+	if !ok {
+		return nil, errors.New("unexpected host " + req.URL.Host)
+	}
+	target, _ := url.Parse(srv.URL)
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = target.Scheme, target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestPreviewTickDoesNotPostOrWriteState(t *testing.T) {
+	cmc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "info") {
+			_, _ = w.Write([]byte(`{"data":{"5426":{"logo":"https://logo/sol.png"}}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":{"error_code":0},"data":[
+			{"id":1,"name":"Bitcoin","symbol":"BTC","cmc_rank":1,"quote":{"USD":{"market_cap":1000}}},
+			{"id":5426,"name":"Solana","symbol":"SOL","cmc_rank":2,"quote":{"USD":{"market_cap":600}}}
+		]}`))
+	}))
+	defer cmc.Close()
+	var telegramCalls atomic.Int32
+	telegram := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		telegramCalls.Add(1)
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+	}))
+	defer telegram.Close()
+	client := &http.Client{Transport: hostRewriter{"pro-api.coinmarketcap.com": cmc, "api.telegram.org": telegram}}
+
+	updated := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	state := &fakeCollection{one: stateDoc{ID: "top", UpdatedAt: updated, TopN: 2, Convert: "USD", IDs: []int64{1, 1027}}}
+	coins := &fakeCollection{}
+	for _, d := range buildStateCoinDocs("top", rankedCoins(1, 1027), map[int64]bool{1: true, 1027: true}, updated) {
+		coins.docs = append(coins.docs, d)
+	}
+	history := &fakeCollection{}
+	logos := &fakeLogoStore{}
+	cfg := Config{TopN: 2, TelegramToken: "token", TelegramChannelID: "channel", LogoCacheTTL: time.Hour}
+
+	// state, coins and history only expose reads, so previewTick can't write them.
+	preview, err := previewTick(context.Background(), client, cfg, RunOptions{DryRun: true, NotifyExits: true, Convert: "USD"}, state, coins, history, logos)
+	if err != nil {
+		t.Fatalf("previewTick error: %v", err)
+	}
+	if got := telegramCalls.Load(); got != 0 {
+		t.Fatalf("preview must not call Telegram, got %d request(s)", got)
+	}
+	if len(preview.NewCoins) != 1 || preview.NewCoins[0].Symbol != "SOL" {
+		t.Fatalf("unexpected new coins: %+v", preview.NewCoins)
+	}
+	if len(preview.ExitedCoins) != 1 || preview.ExitedCoins[0].ID != 1027 {
+		t.Fatalf("unexpected exited coins: %+v", preview.ExitedCoins)
+	}
+	if preview.TopN != 2 || preview.Convert != "USD" || !strings.Contains(preview.Text, "Solana") {
+		t.Fatalf("unexpected preview: %+v", preview)
+	}
+}
+
+func TestPreviewTickWithoutBaseline(t *testing.T) {
+	cmc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[{"id":1,"name":"Bitcoin","symbol":"BTC","cmc_rank":1}]}`))
+	}))
+	defer cmc.Close()
+	client := &http.Client{Transport: hostRewriter{"pro-api.coinmarketcap.com": cmc}}
+
+	_, err := previewTick(context.Background(), client, Config{TopN: 1}, RunOptions{Convert: "USD"}, &fakeCollection{}, &fakeCollection{}, &fakeCollection{}, nil)
+	if !errors.Is(err, ErrNoState) {
+		t.Fatalf("expected ErrNoState, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestComputeRankMoves(t *testing.T) {
+	prev := []Coin{
+		{ID: 1, Symbol: "BTC", Rank: 1},
+		{ID: 2, Symbol: "UP", Rank: 40},
+		{ID: 3, Symbol: "DOWN", Rank: 10},
+		{ID: 4, Symbol: "LEFT", Rank: 99},
+		{ID: 5, Symbol: "SMALL", Rank: 20},
+	}
+	current := []Coin{
+		{ID: 1, Symbol: "BTC", Rank: 1},
+		{ID: 2, Symbol: "UP", Rank: 25},
+		{ID: 3, Symbol: "DOWN", Rank: 30},
+		{ID: 5, Symbol: "SMALL", Rank: 22},
+		{ID: 6, Symbol: "JOINED", Rank: 50},
+	}
+
+	moved := computeRankMoves(prev, current, 10)
+	if len(moved) != 2 {
+		t.Fatalf("expected 2 moved coins, got %+v", moved)
+	}
+	if moved[0].Symbol != "UP" || moved[0].RankDelta != 15 || moved[0].PrevRank != 40 {
+		t.Fatalf("unexpected climber: %+v", moved[0])
+	}
+	if moved[1].Symbol != "DOWN" || moved[1].RankDelta != -20 || moved[1].PrevRank != 10 {
+		t.Fatalf("unexpected faller: %+v", moved[1])
+	}
+}
+
+func TestComputeRankMovesThresholdBoundaryAndDisabled(t *testing.T) {
+	prev := []Coin{{ID: 1, Rank: 10}, {ID: 2, Rank: 10}}
+	current := []Coin{{ID: 1, Rank: 5}, {ID: 2, Rank: 14}}
+
+	if moved := computeRankMoves(prev, current, 5); len(moved) != 1 || moved[0].ID != 1 {
+		t.Fatalf("expected only the exact-threshold move, got %+v", moved)
+	}
+	if moved := computeRankMoves(prev, current, 0); len(moved) != 0 {
+		t.Fatalf("threshold 0 must disable rank jumps, got %+v", moved)
+	}
+}
+
+func TestRenderContextExposesMovedCoins(t *testing.T) {
+	moved := computeRankMoves([]Coin{{ID: 7, Name: "Solana", Symbol: "SOL", Rank: 12}}, []Coin{{ID: 7, Name: "Solana", Symbol: "SOL", Rank: 5}}, 3)
+	renderCtx := buildRenderContext(Config{TopN: 100}, RunOptions{Convert: "USD"}, []Coin{}, []Coin{}, moved, []RecentPost{})
+
+	got := RenderTemplate("%EACH moved_coins%%symbol% %prev_rank%->%rank% (%rank_delta%)%END_EACH%", renderCtx)
+	if got != "SOL 12->5 (7)" {
+		t.Fatalf("unexpected output: %q", got)
+	}
+	if fallback := RenderTemplate(defaultFallbackTemplate, renderCtx); !strings.Contains(fallback, "#12 → #5 Solana (SOL)") {
+		t.Fatalf("fallback template does not render rank jumps: %q", fallback)
+	}
+}
@@ -0,0 +1,34 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoadTrackedStateTrimsHysteresisBuffer(t *testing.T) {
+	updated := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	state := &fakeCollection{one: stateDoc{ID: "top", UpdatedAt: updated, TopN: 2, Convert: "USD", IDs: []int64{1, 2}}}
+	coins := &fakeCollection{}
+	for _, d := range buildStateCoinDocs("top", rankedCoins(1, 2, 9), map[int64]bool{1: true, 2: true}, updated) {
+		coins.docs = append(coins.docs, d)
+	}
+
+	got, err := loadTrackedState(context.Background(), state, coins)
+	if err != nil {
+		t.Fatalf("loadTrackedState error: %v", err)
+	}
+	if got.TopN != 2 || got.Convert != "USD" || !got.UpdatedAt.Equal(updated) {
+		t.Fatalf("unexpected metadata: %+v", got)
+	}
+	if len(got.Coins) != 2 || got.Coins[0].ID != 1 || got.Coins[1].ID != 2 {
+		t.Fatalf("expected only the top 2 coins, got %+v", got.Coins)
+	}
+}
+
+func TestLoadTrackedStateWithoutBaseline(t *testing.T) {
+	if _, err := loadTrackedState(context.Background(), &fakeCollection{}, &fakeCollection{}); !errors.Is(err, ErrNoState) {
+		t.Fatalf("expected ErrNoState, got %v", err)
+	}
+}
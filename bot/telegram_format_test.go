@@ -10,3 +10,21 @@ func TestFormatTelegramHTML(t *testing.T) {
 		t.Fatalf("unexpected output:\nwant: %q\ngot:  %q", want, got)
 	}
 }
+
+func TestFormatTelegramHTMLKeepsCoinLinks(t *testing.T) {
+	ctx := buildRenderContext(Config{TopN: 100}, RunOptions{Convert: "USD"}, []Coin{{ID: 1, Name: "Bitcoin & Co", Symbol: "BTC", Slug: "bitcoin", Rank: 1}}, []Coin{}, []MovedCoin{}, []RecentPost{})
+	text := RenderTemplate("%EACH new_coins%• **%name:link%** <%symbol%>%END_EACH%", ctx)
+	got := formatTelegramHTML(text)
+	want := `• <b><a href="https://coinmarketcap.com/currencies/bitcoin/">Bitcoin &amp; Co</a></b> &lt;BTC&gt;`
+	if got != want {
+		t.Fatalf("unexpected output:\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestFormatTelegramHTMLEscapesNonHTTPAnchors(t *testing.T) {
+	got := formatTelegramHTML(`<a href="javascript:alert(1)">x</a>`)
+	want := "&lt;a href=&#34;javascript:alert(1)&#34;&gt;x&lt;/a&gt;"
+	if got != want {
+		t.Fatalf("unexpected output:\nwant: %q\ngot:  %q", want, got)
+	}
+}
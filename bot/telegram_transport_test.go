@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 )
 
 type captureRoundTripper struct {
@@ -78,3 +80,298 @@ func TestSendTelegramPhotoLongCaptionFallsBackWithoutDoubleEscaping(t *testing.T
 		t.Fatalf("fallback text was double-escaped: %q", text)
 	}
 }
+
+func TestSendTelegramMessageSplitsLongText(t *testing.T) {
+	rt := &captureRoundTripper{}
+	client := &http.Client{Transport: rt}
+	cfg := Config{TelegramToken: "token", TelegramChannelID: "channel"}
+	line := "• #42 **Some Coin** (SOME) — mcap: $1.23B"
+	text := strings.TrimSuffix(strings.Repeat(line+"\n", 250), "\n")
+
+	msgID, err := sendTelegramMessage(context.Background(), client, cfg, text, "")
+	if err != nil {
+		t.Fatalf("sendTelegramMessage error: %v", err)
+	}
+	if msgID == nil || *msgID != 42 {
+		t.Fatalf("unexpected message id: %v", msgID)
+	}
+	if len(rt.requests) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(rt.requests))
+	}
+	for i, r := range rt.requests {
+		chunk, _ := r.payload["text"].(string)
+		if n := len([]rune(chunk)); n > telegramMessageLimit {
+			t.Fatalf("chunk %d has %d runes", i, n)
+		}
+		if strings.Count(chunk, "<b>") != strings.Count(chunk, "</b>") {
+			t.Fatalf("chunk %d splits a bold tag: %q", i, chunk)
+		}
+		if !strings.HasPrefix(chunk, "• #42") || !strings.HasSuffix(chunk, "$1.23B") {
+			t.Fatalf("chunk %d does not break on a line boundary", i)
+		}
+	}
+}
+
+func TestSendTelegramMessageShortTextIsSingleRequest(t *testing.T) {
+	rt := &captureRoundTripper{}
+	client := &http.Client{Transport: rt}
+	cfg := Config{TelegramToken: "token", TelegramChannelID: "channel"}
+
+	if _, err := sendTelegramMessage(context.Background(), client, cfg, "hello", ""); err != nil {
+		t.Fatalf("sendTelegramMessage error: %v", err)
+	}
+	if len(rt.requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(rt.requests))
+	}
+}
+
+func TestSendTelegramMessageLongTextWithImageUsesCaptionChunk(t *testing.T) {
+	rt := &captureRoundTripper{}
+	client := &http.Client{Transport: rt}
+	cfg := Config{TelegramToken: "token", TelegramChannelID: "channel"}
+	text := strings.Repeat("line of text\n", 400)
+
+	msgID, err := sendTelegramMessage(context.Background(), client, cfg, text, "https://example.com/img.png")
+	if err != nil {
+		t.Fatalf("sendTelegramMessage error: %v", err)
+	}
+	if msgID == nil || *msgID != 43 {
+		t.Fatalf("expected photo message id, got %v", msgID)
+	}
+	if len(rt.requests) < 2 || !strings.Contains(rt.requests[0].url, "sendPhoto") {
+		t.Fatalf("expected photo followed by text chunks, got %d requests", len(rt.requests))
+	}
+	caption, _ := rt.requests[0].payload["caption"].(string)
+	if caption == "" || len([]rune(caption)) > telegramCaptionLimit {
+		t.Fatalf("unexpected caption length %d", len([]rune(caption)))
+	}
+	for _, r := range rt.requests[1:] {
+		chunk, _ := r.payload["text"].(string)
+		if len([]rune(chunk)) > telegramMessageLimit {
+			t.Fatalf("chunk exceeds limit: %d", len([]rune(chunk)))
+		}
+	}
+}
+
+func TestSplitTelegramTextKeepsAnchorsIntact(t *testing.T) {
+	anchor := `<a href="https://coinmarketcap.com/currencies/bitcoin-cash/">Bitcoin Cash Extra Long Name</a>`
+	text := strings.Repeat("a", 40) + " " + anchor + " tail"
+	chunks := splitTelegramText(text, 100, 100)
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d: %q", len(chunks), chunks)
+	}
+	if strings.Contains(chunks[0], "<a ") || !strings.HasPrefix(chunks[1], anchor) {
+		t.Fatalf("anchor was split: %q", chunks)
+	}
+
+	bold := strings.Repeat("a", 40) + " <b>" + anchor + "</b> tail"
+	chunks = splitTelegramText(bold, 120, 120)
+	if len(chunks) != 2 || !strings.HasPrefix(chunks[1], "<b>"+anchor+"</b>") {
+		t.Fatalf("bold anchor was split: %q", chunks)
+	}
+
+	openTag := `<a href="https://coinmarketcap.com/currencies/x/">`
+	for _, long := range []string{openTag + strings.Repeat("x", 150) + "</a>", "<b>" + openTag + strings.Repeat("x y ", 40) + "</a></b>"} {
+		chunks := splitTelegramText(long, 100, 100)
+		if len(chunks) < 2 {
+			t.Fatalf("expected the oversized anchor to be split: %q", chunks)
+		}
+		for _, c := range chunks {
+			if len([]rune(c)) > 100 || !strings.Contains(c, openTag) || strings.Count(c, "<a ") != strings.Count(c, "</a>") || strings.Count(c, "<b>") != strings.Count(c, "</b>") {
+				t.Fatalf("oversized anchor produced invalid chunk %q", c)
+			}
+		}
+	}
+}
+
+func TestSplitTelegramTextKeepsBoldSpansIntact(t *testing.T) {
+	text := strings.Repeat("a", 90) + " <b>" + strings.Repeat("b", 20) + "</b> tail"
+	chunks := splitTelegramText(text, 100, 100)
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d: %q", len(chunks), chunks)
+	}
+	if strings.Contains(chunks[0], "<b>") || !strings.HasPrefix(chunks[1], "<b>") {
+		t.Fatalf("bold span was split: %q", chunks)
+	}
+
+	long := "<b>" + strings.Repeat("x", 150) + "</b>"
+	for _, c := range splitTelegramText(long, 100, 100) {
+		if len([]rune(c)) > 100 || strings.Count(c, "<b>") != strings.Count(c, "</b>") {
+			t.Fatalf("oversized bold span produced invalid chunk %q", c)
+		}
+	}
+}
+
+type rateLimitedRoundTripper struct {
+	captureRoundTripper
+	limited    int
+	retryAfter int
+}
+
+func (r *rateLimitedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.limited > 0 {
+		r.limited--
+		_, _ = io.ReadAll(req.Body)
+		retryAfter := r.retryAfter
+		if retryAfter == 0 {
+			retryAfter = 1
+		}
+		return &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Status:     "429 Too Many Requests",
+			Body:       io.NopCloser(bytes.NewBufferString(fmt.Sprintf(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after %d","parameters":{"retry_after":%d}}`, retryAfter, retryAfter))),
+			Header:     make(http.Header),
+		}, nil
+	}
+	return r.captureRoundTripper.RoundTrip(req)
+}
+
+func shrinkTelegramRetryUnit(t *testing.T) {
+	prev := telegramRetryUnit
+	telegramRetryUnit = time.Millisecond
+	t.Cleanup(func() { telegramRetryUnit = prev })
+}
+
+func TestSendTelegramMessageRetriesAfterRateLimit(t *testing.T) {
+	shrinkTelegramRetryUnit(t)
+	rt := &rateLimitedRoundTripper{limited: 1}
+	client := &http.Client{Transport: rt}
+	cfg := Config{TelegramToken: "token", TelegramChannelID: "channel"}
+
+	msgID, err := sendTelegramMessageFormatted(context.Background(), client, cfg, "hello")
+	if err != nil {
+		t.Fatalf("sendTelegramMessageFormatted error: %v", err)
+	}
+	if msgID == nil || *msgID != 42 {
+		t.Fatalf("unexpected message id: %v", msgID)
+	}
+	if len(rt.requests) != 1 {
+		t.Fatalf("expected 1 successful request after retry, got %d", len(rt.requests))
+	}
+}
+
+func TestSendTelegramPhotoRetriesAfterRateLimit(t *testing.T) {
+	shrinkTelegramRetryUnit(t)
+	rt := &rateLimitedRoundTripper{limited: 1}
+	client := &http.Client{Transport: rt}
+	cfg := Config{TelegramToken: "token", TelegramChannelID: "channel"}
+
+	msgID, err := sendTelegramPhoto(context.Background(), client, cfg, "https://example.com/img.png", "caption")
+	if err != nil {
+		t.Fatalf("sendTelegramPhoto error: %v", err)
+	}
+	if msgID == nil || *msgID != 43 {
+		t.Fatalf("unexpected message id: %v", msgID)
+	}
+}
+
+func TestPostTelegramFailsOnExcessiveRetryAfter(t *testing.T) {
+	rt := &rateLimitedRoundTripper{limited: 1, retryAfter: 3600}
+	client := &http.Client{Transport: rt}
+
+	start := time.Now()
+	_, err := postTelegram(context.Background(), client, "https://api.telegram.org/bottoken/sendMessage", []byte(`{}`))
+	if err == nil || !strings.Contains(err.Error(), "retry_after=3600s") {
+		t.Fatalf("expected retry_after error, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("postTelegram slept instead of failing fast")
+	}
+	if rt.limited != 0 || len(rt.requests) != 0 {
+		t.Fatalf("expected a single rate-limited attempt and no retry")
+	}
+}
+
+func TestSendTelegramMessageGivesUpAfterRepeatedRateLimits(t *testing.T) {
+	shrinkTelegramRetryUnit(t)
+	rt := &rateLimitedRoundTripper{limited: telegramMaxRetries + 1}
+	client := &http.Client{Transport: rt}
+	cfg := Config{TelegramToken: "token", TelegramChannelID: "channel"}
+
+	if _, err := sendTelegramMessageFormatted(context.Background(), client, cfg, "hello"); err == nil {
+		t.Fatalf("expected error after exhausting retries")
+	}
+	if len(rt.requests) != 0 {
+		t.Fatalf("expected no successful requests, got %d", len(rt.requests))
+	}
+}
+
+func TestSendTelegramMessageFallsBackToTextWhenPhotoStaysRateLimited(t *testing.T) {
+	shrinkTelegramRetryUnit(t)
+	rt := &rateLimitedRoundTripper{limited: telegramMaxRetries + 1}
+	client := &http.Client{Transport: rt}
+	cfg := Config{TelegramToken: "token", TelegramChannelID: "channel"}
+
+	msgID, err := sendTelegramMessage(context.Background(), client, cfg, "hello", "https://example.com/img.png")
+	if err != nil {
+		t.Fatalf("sendTelegramMessage error: %v", err)
+	}
+	if msgID == nil || *msgID != 42 {
+		t.Fatalf("expected text fallback message id, got %v", msgID)
+	}
+	if len(rt.requests) != 1 || !strings.Contains(rt.requests[0].url, "sendMessage") {
+		t.Fatalf("expected a single sendMessage fallback, got %+v", rt.requests)
+	}
+}
+
+func TestSendTelegramMessageFansOutToEveryChannel(t *testing.T) {
+	rt := &captureRoundTripper{}
+	client := &http.Client{Transport: rt}
+	cfg := Config{TelegramToken: "token", TelegramChannelID: "chanA, chanB"}
+
+	msgID, err := sendTelegramMessage(context.Background(), client, cfg, "hello", "")
+	if err != nil {
+		t.Fatalf("sendTelegramMessage error: %v", err)
+	}
+	if msgID == nil || *msgID != 42 {
+		t.Fatalf("unexpected message id: %v", msgID)
+	}
+	if len(rt.requests) != 2 {
+		t.Fatalf("expected 2 sends, got %d", len(rt.requests))
+	}
+	if rt.requests[0].payload["chat_id"] != "chanA" || rt.requests[1].payload["chat_id"] != "chanB" {
+		t.Fatalf("unexpected chat ids: %v, %v", rt.requests[0].payload["chat_id"], rt.requests[1].payload["chat_id"])
+	}
+}
+
+func TestSendTelegramMessageSingleChannelSendsOnce(t *testing.T) {
+	rt := &captureRoundTripper{}
+	client := &http.Client{Transport: rt}
+	cfg := Config{TelegramToken: "token", TelegramChannelID: "chanA"}
+
+	if _, err := sendTelegramMessage(context.Background(), client, cfg, "hello", ""); err != nil {
+		t.Fatalf("sendTelegramMessage error: %v", err)
+	}
+	if len(rt.requests) != 1 {
+		t.Fatalf("expected 1 send, got %d", len(rt.requests))
+	}
+}
+
+func TestSendTelegramMessageContinuesAfterChannelFailure(t *testing.T) {
+	rt := &captureRoundTripper{}
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		if strings.Contains(string(body), `"chanA"`) {
+			return jsonResponse(400, `{"ok":false,"description":"Bad Request: chat not found"}`), nil
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		return rt.RoundTrip(req)
+	})}
+	cfg := Config{TelegramToken: "token", TelegramChannelID: "chanA,chanB"}
+
+	msgID, err := sendTelegramMessage(context.Background(), client, cfg, "hello", "")
+	if err != nil {
+		t.Fatalf("sendTelegramMessage error: %v", err)
+	}
+	if msgID == nil || *msgID != 42 {
+		t.Fatalf("expected message id from chanB, got %v", msgID)
+	}
+	if len(rt.requests) != 1 || rt.requests[0].payload["chat_id"] != "chanB" {
+		t.Fatalf("expected a single successful send to chanB, got %+v", rt.requests)
+	}
+
+	cfg.TelegramChannelID = "chanA"
+	if _, err := sendTelegramMessage(context.Background(), client, cfg, "hello", ""); err == nil {
+		t.Fatalf("expected error when every channel fails")
+	}
+}
@@ -1,6 +1,9 @@
 package bot
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestTemplateFeaturesWork(t *testing.T) {
 	ctx := map[string]any{
@@ -33,3 +36,201 @@ func TestTemplateIfTreatsEmptySlicesAsFalse(t *testing.T) {
 		t.Fatalf("unexpected output: %q", got)
 	}
 }
+
+func TestTemplateFormatters(t *testing.T) {
+	mc := 1234567890.5
+	ctx := map[string]any{
+		"market_cap": &mc,
+		"rank":       int64(1),
+		"missing":    nil,
+		"word":       "abc",
+	}
+	tpl := "%market_cap:compact% %market_cap:usd% %rank:pad2% %missing:compact|n/a% %word:usd|?% [%missing:pad3%]"
+	want := "$1.23B $1,234,567,891 01 n/a ? []"
+	if got := RenderTemplate(tpl, ctx); got != want {
+		t.Fatalf("unexpected output: got %q want %q", got, want)
+	}
+}
+
+func TestFormatCompactBoundaries(t *testing.T) {
+	cases := []struct {
+		in   float64
+		want string
+	}{
+		{0, "$0"},
+		{999, "$999"},
+		{1000, "$1K"},
+		{999999, "$1M"},
+		{1000000, "$1M"},
+		{1500000, "$1.5M"},
+		{999999999, "$1B"},
+		{1e9, "$1B"},
+		{1e12, "$1T"},
+		{2.345e13, "$23.45T"},
+		{-1500000, "-$1.5M"},
+		{-12.345, "-$12.35"},
+		{-0.001, "$0"},
+	}
+	for _, c := range cases {
+		if got := formatCompact(c.in, "USD"); got != c.want {
+			t.Errorf("formatCompact(%v) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	cases := map[float64]string{0: "$0", 999: "$999", 1000: "$1,000", 1234567.6: "$1,234,568", -45000: "-$45,000", -0.4: "$0"}
+	for in, want := range cases {
+		if got := formatAmount(in, "USD"); got != want {
+			t.Errorf("formatAmount(%v) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTemplateMoneyFormattersUseValueCurrency(t *testing.T) {
+	usd, eur := 1.5e9, 920.0
+	coins := []Coin{
+		{Symbol: "BTC", MarketCap: &usd, MarketCapCurrency: "USD", MarketCaps: map[string]float64{"USD": usd, "EUR": 1.38e9, "CHF": -1.3e9}},
+		{Symbol: "NOE", MarketCap: &eur, MarketCapCurrency: "EUR", MarketCaps: map[string]float64{"EUR": eur}},
+	}
+	renderCtx := buildRenderContext(Config{TopN: 2}, RunOptions{Convert: "USD,EUR,CHF"}, coins, []Coin{}, []MovedCoin{}, []RecentPost{})
+	tpl := "%EACH new_coins%%symbol% %market_cap:compact% %market_cap_EUR:compact% %market_cap_CHF:usd|-%;%END_EACH%"
+	want := "BTC $1.5B €1.38B -1,300,000,000 CHF;NOE €920 €920 -;"
+	if got := RenderTemplate(tpl, renderCtx); got != want {
+		t.Fatalf("unexpected output: got %q want %q", got, want)
+	}
+}
+
+func TestTemplateDottedPathsWalkNestedMaps(t *testing.T) {
+	ctx := map[string]any{
+		"market": map[string]any{"price": 42.5, "quote": map[string]any{"USD": map[string]any{"percent_change_24h": -1.5}}},
+		"groups": map[string]any{"top": []any{map[string]any{"coin": map[string]any{"symbol": "BTC"}}}},
+	}
+	tpl := "%market.price% %market.quote.USD.percent_change_24h% %EACH groups.top%[%coin.symbol%]%END_EACH%%IF market.quote.USD% ok%END_IF%"
+	if got, want := RenderTemplate(tpl, ctx), "42.5 -1.5 [BTC] ok"; got != want {
+		t.Fatalf("unexpected output: got %q want %q", got, want)
+	}
+}
+
+func TestTemplateDottedPathsWalkStructs(t *testing.T) {
+	mc := 1500000.0
+	ctx := map[string]any{
+		"coin":    Coin{Name: "Bitcoin", Symbol: "BTC", MarketCap: &mc},
+		"pointer": &Coin{Symbol: "ETH"},
+	}
+	tpl := "%coin.symbol% %coin.name% %coin.market_cap:compact% %pointer.symbol%%IF pointer.market_cap% bad%END_IF%"
+	if got, want := RenderTemplate(tpl, ctx), "BTC Bitcoin $1.5M ETH"; got != want {
+		t.Fatalf("unexpected output: got %q want %q", got, want)
+	}
+}
+
+func TestTemplateDottedPathsMissingSegment(t *testing.T) {
+	ctx := map[string]any{
+		"a":    map[string]any{"b": map[string]any{"c": "deep"}},
+		"flat": "x",
+		"nil":  nil,
+	}
+	tpl := "[%a.missing.c|def%][%flat.b%][%nil.b%][%a.b.c%]%IF a.missing.c%bad%END_IF%%EACH a.missing%bad%END_EACH%"
+	if got, want := RenderTemplate(tpl, ctx), "[def][][][deep]"; got != want {
+		t.Fatalf("unexpected output: got %q want %q", got, want)
+	}
+}
+
+func TestTemplateIfElse(t *testing.T) {
+	ctx := map[string]any{"yes": "y", "no": "", "coins": []any{map[string]any{"name": "BTC"}}}
+	cases := map[string]string{
+		"%IF yes%A%ELSE%B%END_IF%":                                        "A",
+		"%IF no%A%ELSE%B%END_IF%":                                         "B",
+		"%IF missing%A%ELSE%B %yes%%END_IF%":                              "B y",
+		"%IF no%A%END_IF%|%IF yes%C%END_IF%":                              "|C",
+		"%IF yes%%IF no%X%ELSE%Y%END_IF%%ELSE%Z%END_IF%":                  "Y",
+		"%IF no%%IF yes%X%ELSE%Y%END_IF%%ELSE%Z%IF yes%!%END_IF%%END_IF%": "Z!",
+		"%IF coins%%EACH coins%[%name%]%END_EACH%%ELSE%none%END_IF%":      "[BTC]",
+		"%IF no%%EACH coins%[%name%]%END_EACH%%ELSE%none%END_IF%":         "none",
+	}
+	for tpl, want := range cases {
+		if got := RenderTemplate(tpl, ctx); got != want {
+			t.Errorf("RenderTemplate(%q) = %q, want %q", tpl, got, want)
+		}
+	}
+}
+
+func TestTemplateNestedEach(t *testing.T) {
+	ctx := map[string]any{"posts": []any{
+		map[string]any{"text": "a", "coins": []any{map[string]any{"symbol": "BTC"}, map[string]any{"symbol": "ETH"}}},
+		map[string]any{"text": "b", "coins": []any{map[string]any{"symbol": "SOL"}}},
+	}}
+	tpl := "%EACH posts%%text%:%EACH coins%[%symbol%]%END_EACH%;%END_EACH%"
+	if got, want := RenderTemplate(tpl, ctx), "a:[BTC][ETH];b:[SOL];"; got != want {
+		t.Fatalf("unexpected output: got %q want %q", got, want)
+	}
+}
+
+func TestTemplateDateFormatter(t *testing.T) {
+	ctx := map[string]any{
+		"timestamp_utc": "2026-01-02T15:04:05Z",
+		"tick":          time.Date(2026, 3, 4, 9, 30, 0, 0, time.UTC),
+		"garbage":       "not a time",
+	}
+	tpl := "%timestamp_utc:date(Jan 2, 15:04 MST)%|%timestamp_utc:date(2006-01-02)%|%tick:date(15:04)%|%garbage:date(15:04)%|%timestamp_utc:date(nope)%|%missing:date(15:04)|n/a%"
+	want := "Jan 2, 15:04 UTC|2026-01-02|09:30|not a time|2026-01-02T15:04:05Z|n/a"
+	if got := RenderTemplate(tpl, ctx); got != want {
+		t.Fatalf("unexpected output: got %q want %q", got, want)
+	}
+}
+
+func TestTemplateDateFormatterOutputMatchingLayout(t *testing.T) {
+	ctx := map[string]any{"ts": "2026-01-02T15:04:05Z"}
+	tpl := "%ts:date(15:04)%|%ts:date(Jan 2)%|%ts:date(Jan)%|%ts:date(MST)%|%ts:date(.000)%"
+	want := "15:04|Jan 2|Jan|UTC|.000"
+	if got := RenderTemplate(tpl, ctx); got != want {
+		t.Fatalf("unexpected output: got %q want %q", got, want)
+	}
+}
+
+func TestHasLayoutElements(t *testing.T) {
+	for _, layout := range []string{"15:04", "Jan", "Mon", "2006", "PM", "-07:00", "05", "Z07:00"} {
+		if !hasLayoutElements(layout) {
+			t.Errorf("hasLayoutElements(%q) = false", layout)
+		}
+	}
+	for _, layout := range []string{"", "nope", "at ", "xyz"} {
+		if hasLayoutElements(layout) {
+			t.Errorf("hasLayoutElements(%q) = true", layout)
+		}
+	}
+}
+
+func TestTemplateRelativeFormatter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	prev := templateNow
+	templateNow = func() time.Time { return now }
+	t.Cleanup(func() { templateNow = prev })
+
+	ctx := map[string]any{
+		"hours":   now.Add(-2*time.Hour - 10*time.Minute).Format(time.RFC3339),
+		"minutes": now.Add(-5 * time.Minute),
+		"days":    now.Add(-72 * time.Hour).Format(time.RFC3339),
+		"recent":  now.Add(-10 * time.Second).Format(time.RFC3339),
+		"future":  now.Add(3 * time.Hour).Format(time.RFC3339),
+		"garbage": "yesterday",
+	}
+	tpl := "%hours:relative%|%minutes:relative%|%days:relative%|%recent:relative%|%future:relative%|%garbage:relative%"
+	want := "2h ago|5m ago|3d ago|just now|in 3h|yesterday"
+	if got := RenderTemplate(tpl, ctx); got != want {
+		t.Fatalf("unexpected output: got %q want %q", got, want)
+	}
+}
+
+func TestTemplateLinkFormatter(t *testing.T) {
+	ctx := map[string]any{
+		"coin":  map[string]any{"name": "Ethereum", "slug": "ethereum"},
+		"plain": map[string]any{"name": "NoSlug"},
+		"items": []any{map[string]any{"name": "Bitcoin", "slug": "bitcoin"}},
+	}
+	tpl := "%coin.name:link%|%plain.name:link%|%missing.name:link|n/a%|%EACH items%%name:link%%END_EACH%"
+	want := `<a href="https://coinmarketcap.com/currencies/ethereum/">Ethereum</a>|NoSlug|n/a|<a href="https://coinmarketcap.com/currencies/bitcoin/">Bitcoin</a>`
+	if got := RenderTemplate(tpl, ctx); got != want {
+		t.Fatalf("unexpected output: got %q want %q", got, want)
+	}
+}
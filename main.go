@@ -12,7 +12,7 @@ import (
 func main() {
 	dryRun := flag.Bool("dry-run", false, "print final message without sending")
 	notifyExits := flag.Bool("notify-exits", false, "include exited coins in context")
-	convert := flag.String("convert", "USD", "currency for market cap")
+	convert := flag.String("convert", "USD", "comma-separated currencies for market cap (first is primary)")
 	skipMongo := flag.Bool("skip-mongo", false, "test posting flow without MongoDB state/history")
 	testMessage := flag.String("test-message", "", "custom message for posting flow test (works with --skip-mongo)")
 	testImageURL := flag.String("test-image-url", "", "optional image URL for --test-message")
@@ -4,9 +4,11 @@ import (
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 
 	"coinmarketcap_top100_bot/bot"
 	"github.com/aws/aws-lambda-go/events"
@@ -16,6 +18,18 @@ import (
 //go:embed swagger.json
 var embeddedSwaggerSpec []byte
 
+const (
+	defaultHistoryLimit = 3
+	maxHistoryLimit     = 50
+)
+
+// Swapped out in tests to avoid MongoDB, CoinMarketCap and AI dependencies.
+var (
+	loadRecentPosts = bot.LoadRecentPosts
+	previewTick     = bot.PreviewTick
+	loadState       = bot.LoadState
+)
+
 func handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	if req.Path == "/api/docs" || req.Path == "/api/v1/docs" {
 		return events.APIGatewayProxyResponse{
@@ -37,6 +51,60 @@ func handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.API
 		}, nil
 	}
 
+	if req.HTTPMethod == "GET" && req.Path == "/api/v1/history" {
+		limit, err := parseLimit(req.QueryStringParameters["limit"], defaultHistoryLimit, maxHistoryLimit)
+		if err != nil {
+			return jsonError(400, err), nil
+		}
+		cfg, err := bot.ConfigFromEnv(true, false)
+		if err != nil {
+			return jsonError(500, err), nil
+		}
+		posts, err := loadRecentPosts(ctx, cfg, limit)
+		if err != nil {
+			return jsonError(500, err), nil
+		}
+		body, _ := json.Marshal(posts)
+		return events.APIGatewayProxyResponse{StatusCode: 200, Headers: map[string]string{"Content-Type": "application/json"}, Body: string(body)}, nil
+	}
+
+	if req.HTTPMethod == "GET" && req.Path == "/api/v1/state" {
+		cfg, err := bot.ConfigFromEnv(true, false)
+		if err != nil {
+			return jsonError(500, err), nil
+		}
+		state, err := loadState(ctx, cfg)
+		if errors.Is(err, bot.ErrNoState) {
+			return jsonError(404, err), nil
+		}
+		if err != nil {
+			return jsonError(500, err), nil
+		}
+		body, _ := json.Marshal(state)
+		return events.APIGatewayProxyResponse{StatusCode: 200, Headers: map[string]string{"Content-Type": "application/json"}, Body: string(body)}, nil
+	}
+
+	if req.HTTPMethod == "POST" && req.Path == "/api/v1/preview" {
+		cfg, err := bot.ConfigFromEnv(true, false)
+		if err != nil {
+			return jsonError(500, err), nil
+		}
+		convert := os.Getenv("CONVERT")
+		if convert == "" {
+			convert = "USD"
+		}
+		notifyExits := req.QueryStringParameters["notify_exits"] == "true"
+		preview, err := previewTick(ctx, cfg, bot.RunOptions{DryRun: true, NotifyExits: notifyExits, Convert: convert})
+		if errors.Is(err, bot.ErrNoState) {
+			return jsonError(404, err), nil
+		}
+		if err != nil {
+			return jsonError(500, err), nil
+		}
+		body, _ := json.Marshal(preview)
+		return events.APIGatewayProxyResponse{StatusCode: 200, Headers: map[string]string{"Content-Type": "application/json"}, Body: string(body)}, nil
+	}
+
 	if req.HTTPMethod == "POST" && req.Path == "/api/v1/tick" {
 		cfg, err := bot.ConfigFromEnv(false, false)
 		if err != nil {
@@ -59,12 +127,13 @@ func handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.API
 	}
 
 	log.Printf("[topn.handler] invocation started")
-	log.Printf("[topn.handler] env presence: CMC_API_KEY=%t TELEGRAM_COINMARKETCAP_TOP_100_BOT_TOKEN=%t TELEGRAM_COINMARKETCAP_TOP_100_CHANNEL_ID=%t MONGODB_CONNECTION_STRING=%t GEMINI_API_KEY=%t",
+	log.Printf("[topn.handler] env presence: CMC_API_KEY=%t TELEGRAM_COINMARKETCAP_TOP_100_BOT_TOKEN=%t TELEGRAM_COINMARKETCAP_TOP_100_CHANNEL_ID=%t MONGODB_CONNECTION_STRING=%t GEMINI_API_KEY=%t OPENAI_API_KEY=%t",
 		os.Getenv("CMC_API_KEY") != "",
 		os.Getenv("TELEGRAM_COINMARKETCAP_TOP_100_BOT_TOKEN") != "",
 		os.Getenv("TELEGRAM_COINMARKETCAP_TOP_100_CHANNEL_ID") != "",
 		os.Getenv("MONGODB_CONNECTION_STRING") != "",
 		os.Getenv("GEMINI_API_KEY") != "",
+		os.Getenv("OPENAI_API_KEY") != "",
 	)
 
 	cfg, err := bot.ConfigFromEnv(false, false)
@@ -128,6 +197,20 @@ func swaggerUIPage(path string) string {
 </html>`, jsonPath)
 }
 
+func parseLimit(raw string, def, max int) (int, error) {
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return 0, errors.New("limit must be a positive integer")
+	}
+	if n > max {
+		n = max
+	}
+	return n, nil
+}
+
 func jsonError(status int, err error) events.APIGatewayProxyResponse {
 	body, _ := json.Marshal(map[string]string{"error": err.Error()})
 	return events.APIGatewayProxyResponse{StatusCode: status, Headers: map[string]string{"Content-Type": "application/json"}, Body: string(body)}
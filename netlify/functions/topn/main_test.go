@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
+	"coinmarketcap_top100_bot/bot"
 	"github.com/aws/aws-lambda-go/events"
 )
 
@@ -39,3 +43,167 @@ func TestHandlerSwaggerEndpoint(t *testing.T) {
 		t.Fatalf("swagger response does not look like OpenAPI json")
 	}
 }
+
+func setBotEnv(t *testing.T) {
+	t.Setenv("CMC_API_KEY", "test")
+	t.Setenv("MONGODB_CONNECTION_STRING", "mongodb://localhost:27017")
+}
+
+func TestHandlerHistoryEndpoint(t *testing.T) {
+	setBotEnv(t)
+	var gotLimit int
+	prev := loadRecentPosts
+	loadRecentPosts = func(ctx context.Context, cfg bot.Config, limit int) ([]bot.RecentPost, error) {
+		gotLimit = limit
+		return []bot.RecentPost{{CreatedAtUTC: "2026-01-02T15:04:05Z", Text: "hello", MentionedCoins: []bot.Coin{{ID: 1, Symbol: "BTC", Rank: 1}}}}, nil
+	}
+	t.Cleanup(func() { loadRecentPosts = prev })
+
+	resp, err := handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/api/v1/history", QueryStringParameters: map[string]string{"limit": "500"}})
+	if err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("unexpected status: %d, body: %s", resp.StatusCode, resp.Body)
+	}
+	if got := resp.Headers["Content-Type"]; got != "application/json" {
+		t.Fatalf("unexpected content type: %s", got)
+	}
+	if gotLimit != maxHistoryLimit {
+		t.Fatalf("limit should be capped at %d, got %d", maxHistoryLimit, gotLimit)
+	}
+	var posts []map[string]any
+	if err := json.Unmarshal([]byte(resp.Body), &posts); err != nil {
+		t.Fatalf("response is not a JSON array: %v: %s", err, resp.Body)
+	}
+	if len(posts) != 1 || posts[0]["text"] != "hello" || posts[0]["created_at_utc"] == nil || posts[0]["mentioned_coins"] == nil {
+		t.Fatalf("unexpected history payload: %s", resp.Body)
+	}
+}
+
+func TestHandlerHistoryDefaultsAndErrors(t *testing.T) {
+	setBotEnv(t)
+	var gotLimit int
+	prev := loadRecentPosts
+	loadRecentPosts = func(ctx context.Context, cfg bot.Config, limit int) ([]bot.RecentPost, error) {
+		gotLimit = limit
+		return nil, errors.New("mongo down")
+	}
+	t.Cleanup(func() { loadRecentPosts = prev })
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/api/v1/history"})
+	if gotLimit != defaultHistoryLimit {
+		t.Fatalf("expected default limit %d, got %d", defaultHistoryLimit, gotLimit)
+	}
+	if resp.StatusCode != 500 || !strings.Contains(resp.Body, `"error":"mongo down"`) {
+		t.Fatalf("unexpected error response: %d %s", resp.StatusCode, resp.Body)
+	}
+
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/api/v1/history", QueryStringParameters: map[string]string{"limit": "abc"}})
+	if resp.StatusCode != 400 {
+		t.Fatalf("expected 400 for invalid limit, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerPreviewEndpoint(t *testing.T) {
+	setBotEnv(t)
+	t.Setenv("TOP_N", "")
+	t.Setenv("CONVERT", "")
+	var gotOpt bot.RunOptions
+	prev := previewTick
+	previewTick = func(ctx context.Context, cfg bot.Config, opt bot.RunOptions) (bot.TickPreview, error) {
+		gotOpt = opt
+		return bot.TickPreview{
+			NewCoins:    []bot.Coin{{ID: 1, Name: "Bitcoin", Symbol: "BTC", Rank: 1}},
+			ExitedCoins: []bot.Coin{},
+			TopN:        cfg.TopN,
+			Convert:     opt.Convert,
+			Text:        "preview",
+		}, nil
+	}
+	t.Cleanup(func() { previewTick = prev })
+
+	resp, err := handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/api/v1/preview"})
+	if err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("unexpected status: %d, body: %s", resp.StatusCode, resp.Body)
+	}
+	if !gotOpt.DryRun {
+		t.Fatalf("preview must run in dry-run mode")
+	}
+	var payload map[string]any
+	if err := json.Unmarshal([]byte(resp.Body), &payload); err != nil {
+		t.Fatalf("response is not JSON: %v: %s", err, resp.Body)
+	}
+	coins, ok := payload["new_coins"].([]any)
+	if !ok || len(coins) != 1 {
+		t.Fatalf("expected new_coins array, got: %s", resp.Body)
+	}
+	if payload["text"] != "preview" || payload["convert"] != "USD" || payload["top_n"] != float64(100) {
+		t.Fatalf("unexpected preview payload: %s", resp.Body)
+	}
+	if _, ok := payload["message_id"]; ok {
+		t.Fatalf("preview must not report a Telegram message: %s", resp.Body)
+	}
+}
+
+func TestHandlerStateEndpoint(t *testing.T) {
+	setBotEnv(t)
+	updated := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	prev := loadState
+	loadState = func(ctx context.Context, cfg bot.Config) (bot.TrackedState, error) {
+		return bot.TrackedState{UpdatedAt: updated, TopN: 2, Convert: "USD", Coins: []bot.Coin{
+			{ID: 1, Name: "Bitcoin", Symbol: "BTC", Rank: 1},
+			{ID: 1027, Name: "Ethereum", Symbol: "ETH", Rank: 2},
+		}}, nil
+	}
+	t.Cleanup(func() { loadState = prev })
+
+	resp, err := handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/api/v1/state"})
+	if err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("unexpected status: %d, body: %s", resp.StatusCode, resp.Body)
+	}
+	var state struct {
+		UpdatedAt string `json:"updated_at"`
+		TopN      int    `json:"top_n"`
+		Convert   string `json:"convert"`
+		Coins     []struct {
+			Symbol string `json:"symbol"`
+			Rank   int    `json:"rank"`
+		} `json:"coins"`
+	}
+	if err := json.Unmarshal([]byte(resp.Body), &state); err != nil {
+		t.Fatalf("response is not JSON: %v: %s", err, resp.Body)
+	}
+	if state.UpdatedAt != "2026-01-02T15:04:05Z" || state.TopN != 2 || state.Convert != "USD" {
+		t.Fatalf("unexpected state metadata: %s", resp.Body)
+	}
+	if len(state.Coins) != 2 || state.Coins[0].Symbol != "BTC" || state.Coins[1].Rank != 2 {
+		t.Fatalf("unexpected state coins: %s", resp.Body)
+	}
+}
+
+func TestHandlerStateErrors(t *testing.T) {
+	setBotEnv(t)
+	prev := loadState
+	loadState = func(ctx context.Context, cfg bot.Config) (bot.TrackedState, error) {
+		return bot.TrackedState{}, bot.ErrNoState
+	}
+	t.Cleanup(func() { loadState = prev })
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/api/v1/state"})
+	if resp.StatusCode != 404 || !strings.Contains(resp.Body, `"error":"no baseline state found"`) {
+		t.Fatalf("expected 404 without baseline, got %d %s", resp.StatusCode, resp.Body)
+	}
+
+	t.Setenv("MONGODB_CONNECTION_STRING", "")
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/api/v1/state"})
+	if resp.StatusCode != 500 || !strings.Contains(resp.Body, "MONGODB_CONNECTION_STRING") {
+		t.Fatalf("expected config error JSON, got %d %s", resp.StatusCode, resp.Body)
+	}
+}